@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/seibert-media/inf-insight/pkg/insight"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// newAccessLog returns nil if access logging is disabled
+func newAccessLog(fields []zapcore.Field) (*insight.AccessLog, error) {
+	if !*accessEnabled {
+		return nil, nil
+	}
+	var encoder zapcore.Encoder
+	switch *accessEncoding {
+	case "json":
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	case "console":
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	default:
+		return nil, fmt.Errorf("access.encoding must be json or console, not %q", *accessEncoding)
+	}
+	core := zapcore.NewCore(encoder, zapcore.Lock(os.Stdout), zapcore.InfoLevel)
+	return &insight.AccessLog{
+		Log:             zap.New(core).With(fields...),
+		Sample:          *accessSample,
+		RequestIDHeader: *accessRequestID,
+	}, nil
+}
+
+// withAccessLog wraps h if access logging is enabled
+func withAccessLog(a *insight.AccessLog, h http.Handler, routers ...*mux.Router) http.Handler {
+	if a == nil {
+		return h
+	}
+	return a.Handler(h, routers...)
+}
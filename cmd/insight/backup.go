@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/seibert-media/inf-insight/pkg/backup"
+	"github.com/seibert-media/inf-insight/pkg/insight"
+	"go.uber.org/zap"
+)
+
+func newBackupScheduler(log *zap.Logger, db insight.DB) (backup.Scheduler, error) {
+	sched := backup.Scheduler{
+		Log:      log.With(zap.String("component", "backup")),
+		Db:       db,
+		TempDir:  filepath.Dir(*dbPtr),
+		Interval: *backupInterval,
+		Keep:     *backupKeep,
+	}
+	switch {
+	case *backupDir != "" && *backupS3 != "":
+		return sched, errors.New("only one of -backup.dir and -backup.s3.endpoint may be set")
+	case *backupDir != "":
+		sched.Target = backup.Directory{Path: *backupDir}
+	case *backupS3 != "":
+		if *backupS3Bucket == "" {
+			return sched, errors.New("-backup.s3.bucket is required")
+		}
+		sched.Target = backup.S3{
+			Endpoint:  *backupS3,
+			Region:    *backupS3Region,
+			Bucket:    *backupS3Bucket,
+			Prefix:    *backupS3Prefix,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}
+	default:
+		return sched, errors.New("scheduled backups enabled without -backup.dir or -backup.s3.endpoint")
+	}
+	log.Info("scheduled backups enabled", zap.Duration("interval", *backupInterval), zap.Int("keep", *backupKeep))
+	return sched, nil
+}
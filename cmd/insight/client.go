@@ -0,0 +1,14 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// serverURL is where send and query reach a running server, INSIGHT_URL overrides the default
+func serverURL() string {
+	if v := os.Getenv(envName("url")); v != "" {
+		return strings.TrimSuffix(v, "/")
+	}
+	return "http://localhost:8080"
+}
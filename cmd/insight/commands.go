@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kolide/kit/version"
+)
+
+// commands are run as "insight <command> [flags]", without a command insight runs the server
+var commands = map[string]struct {
+	usage string
+	run   func(args []string) int
+}{
+	"serve":       {"run the server, which is the default without a command", serveCommand},
+	"send":        {"count a call on a running server", sendCommand},
+	"query":       {"print totals, seen times or cardinality of a running server", queryCommand},
+	"db":          {"maintain the db file while the server is stopped", dbCommand},
+	"replay":      {"rebuild the aggregates from the event log into a fresh db file", replayCommand},
+	"healthcheck": {"probe the readiness of a running server", healthcheckCommand},
+	"version":     {"print version information", versionCommand},
+}
+
+// runCommand runs the named command and returns the exit code
+func runCommand(name string, args []string) int {
+	if name == "help" {
+		usage()
+		return 0
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", name)
+		usage()
+		return 2
+	}
+	return cmd.run(args)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: insight [flags]")
+	fmt.Fprintln(os.Stderr, "       insight <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	for _, name := range []string{"serve", "send", "query", "db", "replay", "healthcheck", "version"} {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun \"insight <command> -h\" for the flags of a command. The flags of the server are:")
+	flag.PrintDefaults()
+}
+
+// serveCommand runs the server
+//
+// The server flags are global, so they may be given before or after serve.
+func serveCommand(args []string) int {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: insight serve [flags]")
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.Parse(args)
+	if flag.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "serve: unexpected arguments %q\n", flag.Args())
+		return 2
+	}
+	return runServer()
+}
+
+func versionCommand(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	short := fs.Bool("short", false, "print only the version number")
+	fs.Parse(args)
+	if *short {
+		fmt.Println(version.Version().Version)
+		return 0
+	}
+	fmt.Printf("-- //S/M %s --\n", app)
+	version.PrintFull()
+	return 0
+}
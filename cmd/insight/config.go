@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/seibert-media/inf-insight/pkg/insight"
+	"go.uber.org/zap"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// envPrefix is prepended to the upper cased flag names, with dots replaced by underscores, e.g. INSIGHT_HTTP_ADDR
+const envPrefix = "INSIGHT_"
+
+var (
+	configPtr = flag.String("config", "", "path to a yaml config file, overridden by INSIGHT_* environment variables and command line flags")
+
+	namesMaxLength = flag.Int("names.maxlength", 0, "maximum length of app and type names (unlimited if 0)")
+	namesPattern   = flag.String("names.pattern", "", "regular expression app and type names have to match")
+
+	// cmdline holds the flags set on the command line, which are never overridden
+	cmdline = make(map[string]bool)
+	// applied holds the values taken from the config file and environment
+	applied = make(map[string]string)
+)
+
+// reloadable lists the flags which are applied again on SIGHUP
+var reloadable = map[string]bool{
+	"aliases":         true,
+	"names.maxlength": true,
+	"names.pattern":   true,
+	"limits.apps":     true,
+	"limits.types":    true,
+	"limits.series":   true,
+	"limits.reject":   true,
+	"log.level":       true,
+}
+
+// loadConfig applies the config file and environment to all flags not set on the command line
+//
+// The precedence is command line over environment over config file over defaults.
+func loadConfig() error {
+	flag.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
+	if !cmdline["config"] {
+		if path := os.Getenv(envName("config")); path != "" {
+			*configPtr = path
+		}
+	}
+	values, err := configValues()
+	if err != nil {
+		return err
+	}
+	for name, v := range values {
+		if cmdline[name] {
+			continue
+		}
+		if err := flag.Set(name, v); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", v, name, err)
+		}
+		applied[name] = v
+	}
+	return validateConfig()
+}
+
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(name))
+}
+
+// configValues reads the config file and the environment into flag values
+func configValues() (map[string]string, error) {
+	values := make(map[string]string)
+	if *configPtr != "" {
+		b, err := ioutil.ReadFile(*configPtr)
+		if err != nil {
+			return nil, err
+		}
+		var tree map[interface{}]interface{}
+		if err := yaml.Unmarshal(b, &tree); err != nil {
+			return nil, fmt.Errorf("%s: %v", *configPtr, err)
+		}
+		if err := flatten(flag.CommandLine, "", tree, values); err != nil {
+			return nil, fmt.Errorf("%s: %v", *configPtr, err)
+		}
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && f.Name != "config" {
+			values[f.Name] = v
+		}
+	})
+	return values, nil
+}
+
+// flatten turns nested yaml maps like {http: {addr: ":8080"}} into values of the flags of fs like http.addr=:8080
+func flatten(fs *flag.FlagSet, prefix string, tree map[interface{}]interface{}, values map[string]string) error {
+	for k, v := range tree {
+		name := fmt.Sprint(k)
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		switch v := v.(type) {
+		case map[interface{}]interface{}:
+			if err := flatten(fs, name, v, values); err != nil {
+				return err
+			}
+			continue
+		case []interface{}:
+			return fmt.Errorf("%s: lists are not supported", name)
+		case nil:
+			continue
+		default:
+			values[name] = fmt.Sprint(v)
+		}
+		if f := fs.Lookup(name); f == nil || name == "config" {
+			return fmt.Errorf("unknown setting: %s", name)
+		}
+	}
+	return nil
+}
+
+// validateConfig checks the values of all flags before anything is started
+func validateConfig() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+	check(*httpAddr != "", "http.addr is required")
+	check(*dbPtr != "", "db is required")
+	check(*backupKeep >= 0, "backup.keep must not be negative")
+	check(*backupInterval >= 0, "backup.interval must not be negative")
+	check(*digestPeriod > 0, "digest.period must be positive")
+	check(*retentionInterval >= 0, "retention.interval must not be negative")
+	check(*retentionMinFree >= 0 && *retentionMinFree <= 1, "retention.minfree must be between 0 and 1")
+	check(*retentionEvents >= 0 && *retentionHourly >= 0 && *retentionDaily >= 0, "retention durations must not be negative")
+	check(*metricStale >= 0, "metrics.stale must not be negative")
+	check(!*adminDebug || *adminAddr != "" || *adminToken != "", "admin.debug requires admin.addr or admin.token")
+	check(*tracingExporter == "" || *tracingExporter == "otlp" || *tracingExporter == "stdout", "tracing.exporter must be otlp or stdout")
+	check(*tracingSample >= 0 && *tracingSample <= 1, "tracing.sample must be between 0 and 1")
+	check(*logEncoding == "json" || *logEncoding == "console", "log.encoding must be json or console")
+	check(*errorsReporter == "" || *errorsReporter == "sentry" || *errorsReporter == "log" || *errorsReporter == "none", "errors.reporter must be sentry, log or none")
+	check(*errorsReporter != "sentry" || *sentryDsn != "", "errors.reporter sentry requires sentrydsn")
+	check(*accessSample >= 1, "access.sample must be at least 1")
+	check(*accessEncoding == "json" || *accessEncoding == "console", "access.encoding must be json or console")
+	check(*shutdownDrain >= 0 && *shutdownTimeout >= 0, "shutdown durations must not be negative")
+	check((*tlsCert == "") == (*tlsKey == ""), "tls.cert and tls.key must be set together")
+	check(*tlsClientCA == "" || *tlsCert != "", "tls.client.ca requires tls.cert")
+	check(*tlsClientApps == "" || *tlsClientCA != "", "tls.client.apps requires tls.client.ca")
+	if _, err := newMetricOpts(); err != nil {
+		problems = append(problems, "metrics.labels: "+err.Error())
+	}
+	if _, err := parseLogLevel(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := newValidation(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := newLimits(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New("invalid config: " + strings.Join(problems, ", "))
+	}
+	return nil
+}
+
+func newValidation() (insight.Validation, error) {
+	v := insight.Validation{MaxLength: *namesMaxLength}
+	if v.MaxLength < 0 {
+		return v, errors.New("names.maxlength must not be negative")
+	}
+	if *namesPattern != "" {
+		p, err := regexp.Compile(*namesPattern)
+		if err != nil {
+			return v, fmt.Errorf("names.pattern: %v", err)
+		}
+		v.Pattern = p
+	}
+	return v, nil
+}
+
+func newLimits() (insight.Limits, error) {
+	l := insight.Limits{Apps: *limitApps, Types: *limitTypes, Series: *limitSeries, Reject: *limitReject}
+	if l.Apps < 0 || l.Types < 0 || l.Series < 0 {
+		return l, errors.New("limits must not be negative")
+	}
+	return l, nil
+}
+
+func loadAliasTable() (insight.AliasTable, error) {
+	if *aliasesPtr == "" {
+		return nil, nil
+	}
+	return insight.LoadAliasTable(*aliasesPtr)
+}
+
+// reloadConfig applies the reloadable settings of the config file and environment
+//
+// Changes of other settings are logged, as they require a restart. On errors the previous settings are kept.
+func reloadConfig(log *zap.Logger, aliases *insight.Aliases, rules *insight.Rules) error {
+	values, err := configValues()
+	if err != nil {
+		return err
+	}
+	previous := make(map[string]string)
+	for name := range reloadable {
+		previous[name] = flag.Lookup(name).Value.String()
+	}
+	restore := func() {
+		for name, v := range previous {
+			flag.Set(name, v)
+		}
+	}
+
+	for name := range reloadable {
+		if _, ok := values[name]; !ok && !cmdline[name] {
+			// removed from the config, so the default applies again
+			values[name] = flag.Lookup(name).DefValue
+		}
+	}
+	for name, v := range values {
+		if cmdline[name] {
+			continue
+		}
+		if !reloadable[name] {
+			if v != applied[name] {
+				log.Warn("config change requires a restart", zap.String("setting", name))
+			}
+			continue
+		}
+		if err := flag.Set(name, v); err != nil {
+			restore()
+			return fmt.Errorf("invalid value %q for %s: %v", v, name, err)
+		}
+	}
+
+	validation, err := newValidation()
+	if err != nil {
+		restore()
+		return err
+	}
+	limits, err := newLimits()
+	if err != nil {
+		restore()
+		return err
+	}
+	table, err := loadAliasTable()
+	if err != nil {
+		restore()
+		return err
+	}
+	level, err := parseLogLevel()
+	if err != nil {
+		restore()
+		return err
+	}
+	if err := aliases.SetConfig(table); err != nil {
+		restore()
+		return err
+	}
+	// keep a level set at runtime unless the configured one changed
+	if *logLevelPtr != previous["log.level"] {
+		logLevel.SetLevel(level)
+	}
+	for name := range reloadable {
+		applied[name] = values[name]
+	}
+	rules.Set(validation, limits)
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestFlatten(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", "", "")
+	fs.String("http.addr", "", "")
+	fs.Int("limits.apps", 0, "")
+	fs.Bool("tls.client.required", false, "")
+
+	tests := []struct {
+		yaml string
+		want map[string]string
+		err  bool
+	}{
+		{
+			yaml: "http:\n  addr: :9090\nlimits:\n  apps: 10\ntls:\n  client:\n    required: true\n",
+			want: map[string]string{"http.addr": ":9090", "limits.apps": "10", "tls.client.required": "true"},
+		},
+		{yaml: "limits:\n  apps:\n", want: map[string]string{}},
+		{yaml: "http.addr: :9090\n", want: map[string]string{"http.addr": ":9090"}},
+		{yaml: "http:\n  port: 80\n", err: true},
+		{yaml: "limits:\n  apps: [1, 2]\n", err: true},
+		{yaml: "config: other.yaml\n", err: true},
+	}
+	for _, test := range tests {
+		var tree map[interface{}]interface{}
+		if err := yaml.Unmarshal([]byte(test.yaml), &tree); err != nil {
+			t.Fatal(err)
+		}
+		values := make(map[string]string)
+		err := flatten(fs, "", tree, values)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: got %v, want an error", test.yaml, values)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(values, test.want) {
+			t.Errorf("%q: got %v, %v, want %v", test.yaml, values, err, test.want)
+		}
+	}
+}
+
+// writeConfig writes content into a temporary file, which is removed by the returned func
+func writeConfig(t *testing.T, content string) (string, func()) {
+	f, err := ioutil.TempFile("", "insight-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }
+}
+
+// setEnv sets the environment variables in env, which are unset again by the returned func
+func setEnv(env map[string]string) func() {
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path, remove := writeConfig(t, "http:\n  addr: :9090\nlimits:\n  apps: 10\n  types: 5\n")
+	defer remove()
+	defer setEnv(map[string]string{"INSIGHT_CONFIG": path, "INSIGHT_LIMITS_TYPES": "7"})()
+	defer func(addr string, apps, types int) {
+		*httpAddr, *limitApps, *limitTypes = addr, apps, types
+		*configPtr = ""
+	}(*httpAddr, *limitApps, *limitTypes)
+
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if *configPtr != path {
+		t.Errorf("got config %q, want %q from INSIGHT_CONFIG", *configPtr, path)
+	}
+	if *httpAddr != ":9090" || *limitApps != 10 {
+		t.Errorf("got http.addr %q and limits.apps %d, want the values of the file", *httpAddr, *limitApps)
+	}
+	if *limitTypes != 7 {
+		t.Errorf("got limits.types %d, want 7 from the environment over the file", *limitTypes)
+	}
+}
+
+func TestLoadSendConfig(t *testing.T) {
+	path, remove := writeConfig(t, "url: http://file:8080\ntoken: file\nretries: 5\n")
+	defer remove()
+	defer setEnv(map[string]string{"INSIGHT_TOKEN": "env", "INSIGHT_RETRIES": "6"})()
+
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	url := fs.String("url", "http://localhost:8080", "")
+	token := fs.String("token", "", "")
+	retries := fs.Int("retries", 3, "")
+	if err := fs.Parse([]string{"-retries", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadSendConfig(fs, path); err != nil {
+		t.Fatal(err)
+	}
+	if *url != "http://file:8080" || *token != "env" || *retries != 1 {
+		t.Errorf("got url %q, token %q and retries %d, want the file, environment and command line values", *url, *token, *retries)
+	}
+
+	bad, removeBad := writeConfig(t, "timeout: soon\n")
+	defer removeBad()
+	fs = flag.NewFlagSet("send", flag.ContinueOnError)
+	fs.Duration("timeout", 0, "")
+	if err := loadSendConfig(fs, bad); err == nil {
+		t.Error("loaded an invalid value")
+	}
+}
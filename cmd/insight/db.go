@@ -0,0 +1,202 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/seibert-media/inf-insight/pkg/dbutil"
+	"github.com/seibert-media/inf-insight/pkg/insight"
+	"go.uber.org/zap"
+)
+
+var dbCommands = map[string]struct {
+	usage string
+	run   func(fs *flag.FlagSet, args []string) error
+}{
+	"dump":    {"write the db as json", dbDump},
+	"load":    {"read a json dump into the db", dbLoad},
+	"compact": {"rewrite the db file without free pages", dbCompact},
+	"verify":  {"check db consistency and report invalid counters", dbVerify},
+	"repair":  {"move invalid counters into the quarantine bucket", dbRepair},
+	"migrate": {"upgrade the db to the current schema version", dbMigrate},
+}
+
+// dbCommand runs the offline maintenance subcommands on the db file and returns the exit code
+func dbCommand(args []string) int {
+	if len(args) < 1 {
+		dbUsage()
+		return 2
+	}
+	cmd, ok := dbCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown db command: %s\n", args[0])
+		dbUsage()
+		return 2
+	}
+	fs := flag.NewFlagSet("db "+args[0], flag.ExitOnError)
+	fs.StringVar(dbPtr, "db", *dbPtr, "path to the db file")
+	if err := cmd.run(fs, args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "db %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func dbUsage() {
+	fmt.Fprintln(os.Stderr, "usage: insight db <command> [flags]")
+	for _, name := range []string{"dump", "load", "compact", "verify", "repair", "migrate"} {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, dbCommands[name].usage)
+	}
+}
+
+// openDb opens the db file without waiting for a lock held by a running server
+func openDb(readOnly bool) (*bolt.DB, error) {
+	if readOnly {
+		if _, err := os.Stat(*dbPtr); err != nil {
+			return nil, err
+		}
+	}
+	db, err := bolt.Open(*dbPtr, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%s is locked, stop the server first", *dbPtr)
+	}
+	return db, err
+}
+
+func dbDump(fs *flag.FlagSet, args []string) error {
+	out := fs.String("o", "", "output file (default stdout)")
+	fs.Parse(args)
+	db, err := openDb(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	return dbutil.Dump(db, w)
+}
+
+func dbLoad(fs *flag.FlagSet, args []string) error {
+	in := fs.String("i", "", "input file (default stdin)")
+	merge := fs.Bool("merge", false, "load into a db which already contains data")
+	fs.Parse(args)
+	var r io.Reader = os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	db, err := openDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	if !*merge {
+		var empty = true
+		db.View(func(tx *bolt.Tx) error {
+			return tx.ForEach(func(_ []byte, _ *bolt.Bucket) error {
+				empty = false
+				return nil
+			})
+		})
+		if !empty {
+			return fmt.Errorf("%s is not empty, use -merge to load anyway", *dbPtr)
+		}
+	}
+	n, err := dbutil.Load(db, r)
+	if err != nil {
+		return err
+	}
+	// loaded apps are not part of the running counts
+	if err := db.Update(insight.ResetCounts); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "loaded %d entries into %s\n", n, *dbPtr)
+	return nil
+}
+
+func dbCompact(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	before, after, err := dbutil.Compact(*dbPtr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "compacted %s from %d to %d bytes\n", *dbPtr, before, after)
+	return nil
+}
+
+func dbVerify(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	db, err := openDb(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var problems []insight.Problem
+	err = db.View(func(tx *bolt.Tx) (err error) {
+		problems, err = insight.Verify(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("found %d problems, run \"insight db repair\" to quarantine invalid counters", len(problems))
+	}
+	fmt.Fprintf(os.Stderr, "%s is ok\n", *dbPtr)
+	return nil
+}
+
+func dbRepair(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	db, err := openDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	var moved, remaining int
+	err = db.Update(func(tx *bolt.Tx) error {
+		problems, err := insight.Verify(tx)
+		if err != nil {
+			return err
+		}
+		moved, err = insight.Quarantine(tx, problems)
+		remaining = len(problems) - moved
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "moved %d invalid counters into quarantine\n", moved)
+	if remaining > 0 {
+		return fmt.Errorf("%d problems can not be repaired automatically, see \"insight db verify\"", remaining)
+	}
+	return nil
+}
+
+func dbMigrate(fs *flag.FlagSet, args []string) error {
+	fs.Parse(args)
+	db, err := openDb(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return insight.Migrate(newLogger(false, zap.NewAtomicLevel(), "console"), db)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+
+	"github.com/gorilla/mux"
+	"github.com/seibert-media/inf-insight/pkg/insight"
+)
+
+// debugRouter serves the pprof profiles, a dump of all goroutines and the bolt statistics
+func debugRouter(db *insight.Store) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	r.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index)
+	r.Handle("/debug/goroutines", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		runtimepprof.Lookup("goroutine").WriteTo(w, 2)
+	})).Methods(http.MethodGet)
+	r.Handle("/debug/bolt", insight.StoreStatsHandler(db)).Methods(http.MethodGet)
+	return r
+}
+
+// mountDebug serves the debug router below /debug/ of r, behind token unless it is empty, and returns it
+func mountDebug(r *mux.Router, db *insight.Store, token string) *mux.Router {
+	debug := debugRouter(db)
+	var h http.Handler = debug
+	if token != "" {
+		h = insight.RequireToken(token, debug)
+	}
+	r.PathPrefix("/debug/").Handler(h)
+	return debug
+}
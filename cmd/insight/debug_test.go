@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/seibert-media/inf-insight/pkg/insight"
+)
+
+func TestMountDebugToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := insight.OpenStore(filepath.Join(dir, "insight.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// without admin.addr the internal routes are mounted on the public router
+	public := mux.NewRouter()
+	mountDebug(public, db, "secret")
+	tests := []struct {
+		path, auth string
+		want       int
+	}{
+		{"/debug/pprof/", "", http.StatusUnauthorized},
+		{"/debug/goroutines", "", http.StatusUnauthorized},
+		{"/debug/bolt", "", http.StatusUnauthorized},
+		{"/debug/bolt", "Bearer wrong", http.StatusUnauthorized},
+		{"/debug/pprof/", "Bearer secret", http.StatusOK},
+		{"/debug/bolt", "Bearer secret", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.path, nil)
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		public.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s with %q: got %d, want %d", test.path, test.auth, w.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+package main
+
+import "syscall"
+
+// detachedAttr starts the process like any other, windows has no sessions to leave
+func detachedAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/seibert-media/inf-insight/pkg/digest"
+	"github.com/seibert-media/inf-insight/pkg/insight"
+	"go.uber.org/zap"
+)
+
+func newDigestScheduler(log *zap.Logger, db insight.DB) (digest.Scheduler, error) {
+	sched := digest.Scheduler{
+		Log:    log.With(zap.String("component", "digest")),
+		Db:     db,
+		Period: *digestPeriod,
+	}
+	var err error
+	sched.Schedule, err = digest.ParseSchedule(*digestSchedule)
+	if err != nil {
+		return sched, err
+	}
+	sched.Templates, err = digest.NewTemplates(*digestMarkdownTpl, *digestJSONTpl)
+	if err != nil {
+		return sched, err
+	}
+	if *digestWebhook != "" {
+		sched.Sinks = append(sched.Sinks, digest.Webhook{URL: *digestWebhook})
+	}
+	if *digestDir != "" {
+		sched.Sinks = append(sched.Sinks, digest.Directory{Path: *digestDir})
+	}
+	if len(sched.Sinks) < 1 {
+		return sched, errors.New("digest enabled without -digest.webhook or -digest.dir")
+	}
+	log.Info("digest enabled", zap.String("schedule", *digestSchedule), zap.Duration("period", *digestPeriod))
+	return sched, nil
+}
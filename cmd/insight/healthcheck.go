@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// healthcheckCommand probes the readiness endpoint of a running server and returns the exit code
+//
+// It is meant as docker HEALTHCHECK for images without curl.
+func healthcheckCommand(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ExitOnError)
+	fs.StringVar(configPtr, "config", os.Getenv(envName("config")), "path to the config file of the server (INSIGHT_CONFIG)")
+	addr := fs.String("addr", "", "listen address of the server (default http.addr of the config)")
+	path := fs.String("path", "/readyz", "endpoint to probe, /healthz for liveness")
+	timeout := fs.Duration("timeout", 2*time.Second, "timeout of the probe")
+	useTLS := fs.Bool("tls", false, "probe over https without verifying the server certificate (default true if the config sets tls.cert)")
+	cert := fs.String("cert", "", "client certificate presented to servers requiring one")
+	key := fs.String("key", "", "key of the client certificate")
+	fs.Parse(args)
+
+	// the server settings are read like the server does, so the probe finds it however it is configured
+	values, err := configValues()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		return 2
+	}
+	if *addr == "" {
+		*addr = *httpAddr
+		if v, ok := values["http.addr"]; ok {
+			*addr = v
+		}
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if !set["tls"] {
+		*useTLS = values["tls.cert"] != ""
+	}
+
+	host, port, err := net.SplitHostPort(*addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: invalid address %q: %v\n", *addr, err)
+		return 2
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	client := &http.Client{Timeout: *timeout}
+	scheme := "http"
+	if *useTLS {
+		scheme = "https"
+		// the probe only checks the local server, whose name rarely matches its certificate
+		config := &tls.Config{InsecureSkipVerify: true}
+		if *cert != "" {
+			pair, err := tls.LoadX509KeyPair(*cert, *key)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+				return 2
+			}
+			config.Certificates = []tls.Certificate{pair}
+		}
+		client.Transport = &http.Transport{TLSClientConfig: config}
+	}
+	resp, err := client.Get(scheme + "://" + net.JoinHostPort(host, port) + *path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "healthcheck: %v\n", err)
+		return 1
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "healthcheck: %s\n", resp.Status)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/seibert-media/inf-insight/pkg/tlsutil"
+)
+
+func TestHealthcheckClientCertRequired(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	srv := httptest.NewUnstartedServer(tlsutil.RequireClientCert(h, "/healthz", "/readyz"))
+	srv.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven}
+	srv.StartTLS()
+	defer srv.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	srv.TLS.ClientCAs = pool
+
+	addr := strings.TrimPrefix(srv.URL, "https://")
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/readyz", 0},
+		{"/healthz", 0},
+		{"/metrics", 1},
+	}
+	for _, test := range tests {
+		if code := healthcheckCommand([]string{"-config", "", "-addr", addr, "-tls", "-path", test.path}); code != test.want {
+			t.Errorf("%s: got exit code %d without client certificate, want %d", test.path, code, test.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"sync"
+)
+
+// jobs runs background work until it is stopped
+type jobs struct {
+	done chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+func newJobs() *jobs {
+	return &jobs{done: make(chan struct{})}
+}
+
+// Go runs fn in the background, fn has to return once done is closed
+func (j *jobs) Go(fn func(done <-chan struct{})) {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		fn(j.done)
+	}()
+}
+
+// Stop signals all jobs to return and waits for them, it may be called more than once
+func (j *jobs) Stop() {
+	j.once.Do(func() {
+		close(j.done)
+	})
+	j.wg.Wait()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/kolide/kit/version"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logLevel is the level of the main logger, which can be changed at runtime
+var logLevel = zap.NewAtomicLevel()
+
+// parseLogLevel returns the level set by log.level, which -debug lowers to debug
+func parseLogLevel() (zapcore.Level, error) {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(*logLevelPtr)); err != nil {
+		return level, fmt.Errorf("log.level: %v", err)
+	}
+	if *dbgPtr {
+		level = zapcore.DebugLevel
+	}
+	return level, nil
+}
+
+func logFields() []zapcore.Field {
+	// hide app and version information when debugging
+	if *dbgPtr {
+		return nil
+	}
+	return []zapcore.Field{
+		zap.String("app", appKey),
+		zap.String("version", version.Version().Version),
+	}
+}
+
+// newLogger writes errors to stderr and everything else enabled by level to stdout
+func newLogger(dbg bool, level zap.AtomicLevel, encoding string) *zap.Logger {
+	highPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		return lvl >= zapcore.ErrorLevel && level.Enabled(lvl)
+	})
+	lowPriority := zap.LevelEnablerFunc(func(lvl zapcore.Level) bool {
+		return lvl < zapcore.ErrorLevel && level.Enabled(lvl)
+	})
+
+	consoleDebugging := zapcore.Lock(os.Stdout)
+	consoleErrors := zapcore.Lock(os.Stderr)
+	var encoder zapcore.Encoder
+	if encoding == "json" {
+		encoder = zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	} else {
+		encoder = zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	}
+	core := zapcore.NewTee(
+		zapcore.NewCore(encoder, consoleErrors, highPriority),
+		zapcore.NewCore(encoder, consoleDebugging, lowPriority),
+	)
+	logger := zap.New(core)
+	if dbg {
+		logger = logger.WithOptions(
+			zap.AddCaller(),
+			zap.AddStacktrace(zap.ErrorLevel),
+		)
+	} else {
+		logger = logger.WithOptions(
+			zap.AddStacktrace(zap.FatalLevel),
+		)
+	}
+	return logger
+}
+
+// logLevelHandler reports the current level on GET and sets it on PUT of {"level":"debug"}
+func logLevelHandler(log *zap.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		before := logLevel.Level()
+		logLevel.ServeHTTP(w, r)
+		if after := logLevel.Level(); after != before {
+			log.Warn("changed log level", zap.Stringer("from", before), zap.Stringer("to", after), zap.String("remote", r.RemoteAddr))
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	defer logLevel.SetLevel(logLevel.Level())
+	logLevel.SetLevel(zapcore.InfoLevel)
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := logLevelHandler(zap.New(core))
+
+	r := httptest.NewRequest(http.MethodPut, "/admin/log/level", strings.NewReader(`{"level":"debug"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || logLevel.Level() != zapcore.DebugLevel {
+		t.Fatalf("got status %d and level %s, want debug", w.Code, logLevel.Level())
+	}
+	entries := logs.TakeAll()
+	if len(entries) != 1 || entries[0].Message != "changed log level" || entries[0].Level != zapcore.WarnLevel ||
+		entries[0].ContextMap()["from"] != "info" || entries[0].ContextMap()["to"] != "debug" {
+		t.Errorf("got log entries %v, want the change audited", entries)
+	}
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/log/level", nil))
+	if entries := logs.TakeAll(); len(entries) != 0 {
+		t.Errorf("got log entries %v for reading the level", entries)
+	}
+}
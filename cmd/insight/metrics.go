@@ -0,0 +1,21 @@
+package main
+
+import (
+	"github.com/seibert-media/inf-insight/pkg/insight"
+)
+
+func newMetricOpts() (insight.MetricOpts, error) {
+	labels, err := insight.ParseLabels(*metricConstLabels)
+	if err != nil {
+		return insight.MetricOpts{}, err
+	}
+	return insight.MetricOpts{
+		Namespace:   *metricNamespace,
+		Subsystem:   *metricSubsystem,
+		Name:        *metricName,
+		Help:        *metricHelp,
+		TypeLabel:   *metricTypeLabel,
+		AppLabel:    *metricAppLabel,
+		ConstLabels: labels,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// queryCommand prints the response of a query endpoint of a running server and returns the exit code
+func queryCommand(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	base := fs.String("url", serverURL(), "url of the server, or of its admin listener if it has one (INSIGHT_URL)")
+	appName := fs.String("app", "", "only report this app (totals and seen)")
+	limit := fs.Int("limit", 0, "number of apps and types reported (cardinality, server default if 0)")
+	token := fs.String("token", os.Getenv(envName("token")), "bearer token sent to the server (INSIGHT_TOKEN)")
+	ca := fs.String("ca", "", "CA bundle the server certificate is verified against")
+	cert := fs.String("cert", "", "client certificate presented to servers requiring one")
+	key := fs.String("key", "", "key of the client certificate")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout of the request")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: insight query [flags] totals|seen|cardinality")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	params := url.Values{}
+	switch fs.Arg(0) {
+	case "totals", "seen":
+		if *appName != "" {
+			params.Set("app", *appName)
+		}
+	case "cardinality":
+		if *limit > 0 {
+			params.Set("limit", strconv.Itoa(*limit))
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	u := strings.TrimSuffix(*base, "/") + "/" + fs.Arg(0)
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	client, err := sendClient(*timeout, *ca, *cert, *key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "query: %v\n", err)
+		return 1
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "query: %v\n", err)
+		return 1
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "query: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		fmt.Fprintf(os.Stderr, "query: %s: %s\n", resp.Status, strings.TrimSpace(string(msg)))
+		return 1
+	}
+	if _, err := io.Copy(os.Stdout, resp.Body); err != nil {
+		fmt.Fprintf(os.Stderr, "query: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/seibert-media/inf-insight/pkg/insight"
+	"go.uber.org/zap"
+)
+
+// replayCommand rebuilds all aggregates from the event log into a fresh db file and returns the exit code
+func replayCommand(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(dbPtr, "db", *dbPtr, "path to the db file holding the event log")
+	out := fs.String("o", "", "path of the fresh db file to create")
+	fs.StringVar(aliasesPtr, "aliases", *aliasesPtr, "path to a json alias file applied in addition to the stored aliases")
+	strict := fs.Bool("strict", false, "remove the fresh db file and fail if its totals differ from the original")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: insight replay -db bolt.db -o fresh.db [-aliases aliases.json] [-strict]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *out == "" {
+		fs.Usage()
+		return 2
+	}
+	if err := replay(*out, *strict); err != nil {
+		fmt.Fprintf(os.Stderr, "replay: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// maxDiffs limits the differing series listed after a replay
+const maxDiffs = 20
+
+func replay(out string, strict bool) (err error) {
+	if _, err := os.Stat(out); err == nil {
+		return fmt.Errorf("%s already exists", out)
+	}
+	src, err := openDb(true)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var table insight.AliasTable
+	if *aliasesPtr != "" {
+		if table, err = insight.LoadAliasTable(*aliasesPtr); err != nil {
+			return err
+		}
+	}
+	aliases, err := insight.NewAliases(src, table)
+	if err != nil {
+		return err
+	}
+
+	dst, err := bolt.Open(out, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		dst.Close()
+		if err != nil {
+			os.Remove(out)
+		}
+	}()
+	if err := insight.Migrate(zap.NewNop(), dst); err != nil {
+		return err
+	}
+	start := time.Now()
+	n, err := insight.Replay(src, dst, aliases)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "replayed %d events from %s into %s in %s\n", n, *dbPtr, out, time.Since(start))
+
+	var want, got insight.Totals
+	err = src.View(func(tx *bolt.Tx) (err error) {
+		want, err = insight.ReadTotals(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	err = dst.View(func(tx *bolt.Tx) (err error) {
+		got, err = insight.ReadTotals(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	diffs := insight.DiffTotals(want, got)
+	if len(diffs) == 0 {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "the totals of %d series differ from %s, as changed aggregation or aliases, calls before the event log was enabled or beyond its retention and admin ops are not reproduced:\n", len(diffs), *dbPtr)
+	for i, d := range diffs {
+		if i == maxDiffs {
+			fmt.Fprintf(os.Stderr, "  ... and %d more\n", len(diffs)-maxDiffs)
+			break
+		}
+		fmt.Fprintf(os.Stderr, "  app %q type %q: %d in %s, %d replayed\n", d.App, d.Type, d.Want, *dbPtr, d.Got)
+	}
+	if strict {
+		return fmt.Errorf("removed %s as its totals differ from %s", out, *dbPtr)
+	}
+	fmt.Fprintf(os.Stderr, "kept %s, check the differences before replacing %s with it\n", out, *dbPtr)
+	return nil
+}
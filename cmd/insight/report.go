@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/kolide/kit/version"
+	"github.com/seibert-media/inf-insight/pkg/report"
+	"go.uber.org/zap"
+)
+
+// newReporter picks sentry if a dsn is given and errors.reporter is empty
+func newReporter(log *zap.Logger) (report.Reporter, error) {
+	kind := *errorsReporter
+	if kind == "" {
+		kind = "log"
+		if *sentryDsn != "" {
+			kind = "sentry"
+		}
+	}
+	switch kind {
+	case "sentry":
+		return report.NewSentry(*sentryDsn, version.Version().Version)
+	case "log":
+		return report.Log{Log: log.With(zap.String("component", "report"))}, nil
+	case "none":
+		return report.Nop{}, nil
+	}
+	return nil, fmt.Errorf("unknown error reporter %q", kind)
+}
+
+// panicError turns a recovered value into an error
+func panicError(rval interface{}) error {
+	if err, ok := rval.(error); ok {
+		return err
+	}
+	return errors.New(fmt.Sprint(rval))
+}
+
+func recoveryHandler(reporter report.Reporter, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rval := recover(); rval != nil {
+				reporter.Report(panicError(rval), r, nil)
+			}
+		}()
+		handler(w, r)
+	}
+}
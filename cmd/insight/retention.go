@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/seibert-media/inf-insight/pkg/insight"
+	"go.uber.org/zap"
+)
+
+func newRetentionJob(log *zap.Logger, db *insight.Store) (insight.RetentionJob, error) {
+	job := insight.RetentionJob{
+		Log:   log.With(zap.String("component", "retention")),
+		Store: db,
+		Policy: insight.Retention{
+			Events: *retentionEvents,
+			Hourly: *retentionHourly,
+			Daily:  *retentionDaily,
+		},
+		Interval:     *retentionInterval,
+		Compact:      *retentionCompact,
+		CompactRatio: *retentionMinFree,
+	}
+	if *retentionOverrides != "" {
+		apps, err := insight.LoadRetentionOverrides(*retentionOverrides)
+		if err != nil {
+			log.Error("retention overrides error", zap.String("file", *retentionOverrides), zap.Error(err))
+			return job, err
+		}
+		job.Policy.Apps = apps
+	}
+	return job, nil
+}
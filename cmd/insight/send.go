@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/seibert-media/inf-insight/pkg/insight"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// maxBackoff caps the wait between two attempts of send
+const maxBackoff = 30 * time.Second
+
+// labelFlag collects repeated -label k=v flags
+type labelFlag map[string]string
+
+func (l labelFlag) String() string {
+	var pairs []string
+	for k, v := range l {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (l labelFlag) Set(v string) error {
+	i := strings.Index(v, "=")
+	if i < 1 {
+		return fmt.Errorf("label %q is not of the form key=value", v)
+	}
+	l[v[:i]] = v[i+1:]
+	return nil
+}
+
+// sendCommand counts a call on a running server and returns the exit code
+//
+// Unless -strict is set, failures to reach the server are only reported on stderr,
+// so scripts never fail because of their telemetry.
+func sendCommand(args []string) int {
+	fs := flag.NewFlagSet("send", flag.ExitOnError)
+	config := fs.String("config", defaultSendConfig(), "path to a yaml file with defaults for these flags (INSIGHT_SEND_CONFIG)")
+	appName := fs.String("app", "", "app the call is counted for")
+	ctype := fs.String("type", "", "type of the call")
+	labels := labelFlag{}
+	fs.Var(labels, "label", "label key=value kept in the event log of the server, may be repeated")
+	url := fs.String("url", "http://localhost:8080", "url of the server")
+	token := fs.String("token", "", "bearer token sent to the server")
+	ca := fs.String("ca", "", "CA bundle the server certificate is verified against")
+	cert := fs.String("cert", "", "client certificate presented to servers requiring one")
+	key := fs.String("key", "", "key of the client certificate")
+	retries := fs.Int("retries", 3, "number of retries if the server is unreachable or fails")
+	backoff := fs.Duration("backoff", 500*time.Millisecond, "wait before the first retry, doubled for every further one")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of each attempt")
+	background := fs.Bool("background", false, "send from a detached process and return immediately")
+	strict := fs.Bool("strict", false, "exit with 1 if the call could not be counted")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: insight send -app deploytool -type started [-label k=v] [flags]")
+		fmt.Fprintln(os.Stderr, "Flags can also be set in the config file or as INSIGHT_ plus the upper cased flag name, e.g. INSIGHT_URL.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return 2
+	}
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "insight send: %v\n", err)
+		if *strict {
+			return 1
+		}
+		return 0
+	}
+	if err := loadSendConfig(fs, *config); err != nil {
+		return fail(err)
+	}
+	if *appName == "" || *ctype == "" {
+		fs.Usage()
+		return 2
+	}
+
+	req := insight.Request{App: *appName, Type: *ctype, Labels: labels}
+	if err := req.Validate(); err != nil {
+		// the server would reject the call as well, so it is not sent at all
+		return fail(err)
+	}
+
+	if *background {
+		if err := detach(args); err != nil {
+			return fail(err)
+		}
+		return 0
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fail(err)
+	}
+	client, err := sendClient(*timeout, *ca, *cert, *key)
+	if err != nil {
+		return fail(err)
+	}
+	rand.Seed(time.Now().UnixNano())
+	for attempt := 0; ; attempt++ {
+		retry, err := post(client, strings.TrimSuffix(*url, "/")+"/add", *token, body)
+		if err == nil {
+			return 0
+		}
+		if !retry || attempt >= *retries {
+			return fail(err)
+		}
+		time.Sleep(jitter(backoffDelay(*backoff, attempt)))
+	}
+}
+
+// post sends body and reports whether a failure is worth retrying
+func post(client *http.Client, url, token string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, errors.New(resp.Status)
+	default:
+		// the call itself is rejected, so sending it again would not help
+		return false, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+}
+
+// backoffDelay doubles backoff for every previous attempt, stopping at maxBackoff so large attempts can not overflow
+func backoffDelay(backoff time.Duration, attempt int) time.Duration {
+	d := backoff
+	for i := 0; i < attempt && d > 0 && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d
+}
+
+// jitter spreads d by up to half of it in either direction, so retrying clients do not synchronize
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// sendClient creates the client of send and query, verifying servers against ca and presenting cert if they are set
+func sendClient(timeout time.Duration, ca, cert, key string) (*http.Client, error) {
+	client := &http.Client{Timeout: timeout}
+	if ca == "" && cert == "" {
+		return client, nil
+	}
+	config := &tls.Config{}
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", ca)
+		}
+	}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	client.Transport = &http.Transport{TLSClientConfig: config}
+	return client, nil
+}
+
+// defaultSendConfig is INSIGHT_SEND_CONFIG, or ~/.config/insight/send.yaml if it exists
+func defaultSendConfig() string {
+	if path := os.Getenv(envName("send.config")); path != "" {
+		return path
+	}
+	path := filepath.Join(os.Getenv("HOME"), ".config", "insight", "send.yaml")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// loadSendConfig applies the config file and environment to the flags of fs not set on the command line
+//
+// The precedence is the same as for the server.
+func loadSendConfig(fs *flag.FlagSet, path string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	values := make(map[string]string)
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var tree map[interface{}]interface{}
+		if err := yaml.Unmarshal(b, &tree); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if err := flatten(fs, "", tree, values); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		if v, ok := os.LookupEnv(envName(f.Name)); ok && f.Name != "config" {
+			values[f.Name] = v
+		}
+	})
+	for name, v := range values {
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, v); err != nil {
+			return fmt.Errorf("invalid value %q for %s: %v", v, name, err)
+		}
+	}
+	return nil
+}
+
+// detach runs send with args again in a new session, which outlives the calling script
+func detach(args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, append(append([]string{"send"}, args...), "-background=false")...)
+	cmd.SysProcAttr = detachedAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seibert-media/inf-insight/pkg/insight"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	for _, c := range []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 500 * time.Millisecond},
+		{2, 2 * time.Second},
+		{10, maxBackoff},
+		{100, maxBackoff},
+	} {
+		if got := backoffDelay(500*time.Millisecond, c.attempt); got != c.want {
+			t.Errorf("got %s for attempt %d, want %s", got, c.attempt, c.want)
+		}
+	}
+}
+
+func TestSendRejectsLongLabels(t *testing.T) {
+	posted := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = true
+	}))
+	defer srv.Close()
+	long := strings.Repeat("x", insight.MaxLabelLength+1)
+	code := sendCommand([]string{"-config", "", "-url", srv.URL, "-app", "a", "-type", "t", "-label", "k=" + long, "-strict"})
+	if code != 1 || posted {
+		t.Errorf("got exit code %d and posted %v, want 1 without posting", code, posted)
+	}
+}
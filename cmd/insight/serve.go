@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// serve starts all servers in the background, serving https if they have a tls config
+//
+// Servers failing other than by shutdown send their error on the returned channel.
+func serve(log *zap.Logger, servers []*http.Server) <-chan error {
+	errs := make(chan error, len(servers))
+	for _, h := range servers {
+		go func(h *http.Server) {
+			log.Info("listening", zap.String("address", h.Addr), zap.Bool("tls", h.TLSConfig != nil))
+			var err error
+			if h.TLSConfig != nil {
+				err = h.ListenAndServeTLS("", "")
+			} else {
+				err = h.ListenAndServe()
+			}
+			if err != http.ErrServerClosed {
+				log.Error("server error", zap.String("address", h.Addr), zap.Error(err))
+				errs <- fmt.Errorf("%s: %v", h.Addr, err)
+			}
+		}(h)
+	}
+	return errs
+}
+
+// shutdown gracefully stops all servers at once and returns the first error
+func shutdown(log *zap.Logger, servers []*http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Info("shutting down", zap.Duration("timeout", timeout))
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(servers))
+	for _, h := range servers {
+		wg.Add(1)
+		go func(h *http.Server) {
+			defer wg.Done()
+			if err := h.Shutdown(ctx); err != nil {
+				log.Info("error shutting down", zap.String("address", h.Addr), zap.Error(err))
+				errs <- err
+			}
+		}(h)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	log.Info("shutdown complete")
+	return nil
+}
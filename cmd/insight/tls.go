@@ -0,0 +1,29 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/seibert-media/inf-insight/pkg/tlsutil"
+	"go.uber.org/zap"
+)
+
+// newTLSConfig creates the config of all listeners
+//
+// Client certificates are only verified if given during the handshake, requireClientCert requires them per request.
+func newTLSConfig(log *zap.Logger) (*tls.Config, error) {
+	reloader, err := tlsutil.NewReloader(log.With(zap.String("component", "tls")), *tlsCert, *tlsKey)
+	if err != nil {
+		return nil, err
+	}
+	return tlsutil.ServerConfig(reloader, *tlsClientCA, false)
+}
+
+// requireClientCert rejects requests without client certificate if they are required,
+// except for the health endpoints probed by the healthcheck of the image
+func requireClientCert(h http.Handler) http.Handler {
+	if *tlsClientCA == "" || !*tlsClientRequire {
+		return h
+	}
+	return tlsutil.RequireClientCert(h, "/healthz", "/readyz")
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/seibert-media/inf-insight/pkg/trace"
+	"go.uber.org/zap"
+)
+
+// newTracer returns nil if tracing is disabled
+func newTracer(log *zap.Logger) (*trace.Tracer, error) {
+	var e trace.Exporter
+	switch *tracingExporter {
+	case "":
+		return nil, nil
+	case "stdout":
+		e = trace.NewWriter(os.Stdout)
+	case "otlp":
+		e = trace.NewOTLP(*tracingEndpoint, *tracingService)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", *tracingExporter)
+	}
+	return trace.NewTracer(log.With(zap.String("component", "tracing")), e, *tracingSample), nil
+}
@@ -0,0 +1,192 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/seibert-media/inf-insight/pkg/insight"
+	"go.uber.org/zap"
+)
+
+const (
+	prefix     = "insight-"
+	suffix     = ".db"
+	timeLayout = "20060102T150405Z"
+)
+
+// Name returns the file name of a backup taken at t
+func Name(t time.Time) string {
+	return prefix + t.UTC().Format(timeLayout) + suffix
+}
+
+// isBackup reports whether name was created by Name
+func isBackup(name string) bool {
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return false
+	}
+	_, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix))
+	return err == nil
+}
+
+// Handler streams a consistent snapshot of db taken in a single read transaction
+//
+// The snapshot is copied to a temporary file in dir first, so a slow download never holds the read transaction,
+// which would block compaction and every call waiting behind it.
+func Handler(log *zap.Logger, db insight.DB, dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, size, err := snapshot(db, dir)
+		if err != nil {
+			log.Error("backup snapshot error", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer removeSnapshot(f)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, Name(time.Now())))
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		if _, err := io.Copy(w, f); err != nil {
+			log.Error("backup stream error", zap.Error(err))
+			return
+		}
+		log.Info("streamed backup", zap.String("remote", r.RemoteAddr))
+	}
+}
+
+// snapshot writes a consistent copy of db to a temporary file in dir and returns it positioned at its start
+//
+// Only the copy to the local file runs in the read transaction. The caller removes the file with removeSnapshot.
+func snapshot(db insight.DB, dir string) (*os.File, int64, error) {
+	f, err := ioutil.TempFile(dir, ".tmp-snapshot-")
+	if err != nil {
+		return nil, 0, err
+	}
+	var size int64
+	err = db.View(func(tx *bolt.Tx) error {
+		size, err = tx.WriteTo(f)
+		return err
+	})
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeSnapshot(f)
+		return nil, 0, err
+	}
+	return f, size, nil
+}
+
+func removeSnapshot(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// Target stores backup files
+//
+// Put may read r more than once, size is its length.
+type Target interface {
+	Put(name string, r io.ReadSeeker, size int64) error
+	List() ([]string, error)
+	Delete(name string) error
+}
+
+// WriterTarget is implemented by targets which can store a backup while it is written, without a temporary copy
+type WriterTarget interface {
+	Target
+	Write(name string, write func(w io.Writer) (int64, error)) error
+}
+
+// Scheduler writes timestamped backups to a target and keeps the last Keep copies
+//
+// Unless Target is a WriterTarget, snapshots are copied to a temporary file in TempDir before they are stored.
+// It should be next to the db, as the container image has no /tmp.
+type Scheduler struct {
+	Log      *zap.Logger
+	Db       insight.DB
+	Target   Target
+	TempDir  string
+	Interval time.Duration
+	Keep     int
+}
+
+// Run blocks until done is closed and takes a backup every Interval
+func (s Scheduler) Run(done <-chan struct{}) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if err := s.Backup(now); err != nil {
+				s.Log.Error("backup error", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Backup writes a snapshot taken at t to the target and prunes old copies
+//
+// The db is never held in memory, and the read transaction never waits for a remote target.
+func (s Scheduler) Backup(t time.Time) error {
+	name := Name(t)
+	var size int64
+	var err error
+	if w, ok := s.Target.(WriterTarget); ok {
+		err = w.Write(name, func(dst io.Writer) (int64, error) {
+			err := s.Db.View(func(tx *bolt.Tx) (err error) {
+				size, err = tx.WriteTo(dst)
+				return err
+			})
+			return size, err
+		})
+	} else {
+		var f *os.File
+		f, size, err = snapshot(s.Db, s.TempDir)
+		if err != nil {
+			return err
+		}
+		defer removeSnapshot(f)
+		err = s.Target.Put(name, f, size)
+	}
+	if err != nil {
+		return err
+	}
+	s.Log.Info("wrote backup", zap.String("name", name), zap.Int64("size", size))
+	return s.prune()
+}
+
+// prune deletes all but the newest Keep backups, Keep < 1 keeps everything
+func (s Scheduler) prune() error {
+	if s.Keep < 1 {
+		return nil
+	}
+	names, err := s.Target.List()
+	if err != nil {
+		return err
+	}
+	var backups []string
+	for _, name := range names {
+		if isBackup(name) {
+			backups = append(backups, name)
+		}
+	}
+	if len(backups) <= s.Keep {
+		return nil
+	}
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-s.Keep] {
+		if err := s.Target.Delete(name); err != nil {
+			return err
+		}
+		s.Log.Info("deleted backup", zap.String("name", name))
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.uber.org/zap"
+)
+
+func testDb(t *testing.T, dir string) *bolt.DB {
+	db, err := bolt.Open(filepath.Join(dir, "src.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("insight:apps"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("app"), []byte("value"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestBackupDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := testDb(t, dir)
+	defer db.Close()
+
+	s := Scheduler{Log: zap.NewNop(), Db: db, Target: Directory{Path: filepath.Join(dir, "backups")}, Keep: 2}
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if err := s.Backup(start.Add(time.Duration(i) * time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := s.Target.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != Name(start.Add(time.Hour)) {
+		t.Fatalf("got backups %v, want the newest two", names)
+	}
+
+	restored, err := bolt.Open(filepath.Join(dir, "backups", names[1]), 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	err = restored.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("insight:apps")).Get([]byte("app")); string(v) != "value" {
+			t.Errorf("got %q from the backup, want value", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestS3Put(t *testing.T) {
+	var body []byte
+	var hash string
+	var length int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		hash = r.Header.Get("X-Amz-Content-Sha256")
+		length = r.ContentLength
+	}))
+	defer srv.Close()
+
+	data := bytes.Repeat([]byte("insight"), 1000)
+	s := S3{Endpoint: srv.URL, Region: "us-east-1", Bucket: "b", AccessKey: "a", SecretKey: "s"}
+	if err := s.Put("x.db", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if !bytes.Equal(body, data) || length != int64(len(data)) || hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("got %d bytes with length %d and hash %s", len(body), length, hash)
+	}
+}
+
+// files returns the names in dir
+func files(t *testing.T, dir string) []string {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
+
+func TestBackupS3TempDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := testDb(t, dir)
+	defer db.Close()
+	var length int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			length = r.ContentLength
+		}
+		// an empty listing
+		w.Write([]byte(`<ListBucketResult></ListBucketResult>`))
+	}))
+	defer srv.Close()
+
+	target := S3{Endpoint: srv.URL, Region: "us-east-1", Bucket: "b", AccessKey: "a", SecretKey: "s"}
+	s := Scheduler{Log: zap.NewNop(), Db: db, Target: target, TempDir: dir, Keep: 2}
+	if err := s.Backup(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if length == 0 {
+		t.Error("got an empty upload")
+	}
+	if names := files(t, dir); len(names) != 1 {
+		t.Errorf("got files %v next to the db, want the staged snapshot removed", names)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := testDb(t, dir)
+	defer db.Close()
+
+	w := httptest.NewRecorder()
+	Handler(zap.NewNop(), db, dir)(w, httptest.NewRequest(http.MethodGet, "/admin/backup", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+		t.Fatalf("got status %d with %d bytes and length %s", w.Code, w.Body.Len(), w.Header().Get("Content-Length"))
+	}
+	if names := files(t, dir); len(names) != 1 {
+		t.Errorf("got files %v next to the db, want the staged snapshot removed", names)
+	}
+	path := filepath.Join(dir, "downloaded.db")
+	if err := ioutil.WriteFile(path, w.Body.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	err = restored.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("insight:apps")).Get([]byte("app")); string(v) != "value" {
+			t.Errorf("got %q from the download, want value", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Directory stores backups in a local directory
+type Directory struct {
+	Path string
+}
+
+// Put copies r into the named backup
+func (d Directory) Put(name string, r io.ReadSeeker, size int64) error {
+	return d.Write(name, func(w io.Writer) (int64, error) {
+		return io.Copy(w, r)
+	})
+}
+
+// Write writes the backup to a temporary file first, so partial backups never carry a valid name
+func (d Directory) Write(name string, write func(w io.Writer) (int64, error)) error {
+	if err := os.MkdirAll(d.Path, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(d.Path, ".tmp-"+name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(d.Path, name))
+}
+
+// List returns the names of all files in the directory
+func (d Directory) List() ([]string, error) {
+	infos, err := ioutil.ReadDir(d.Path)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// Delete removes the named backup
+func (d Directory) Delete(name string) error {
+	return os.Remove(filepath.Join(d.Path, name))
+}
@@ -0,0 +1,104 @@
+package dbutil
+
+import (
+	"os"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Copy writes all buckets and keys of src into dst
+func Copy(src, dst *bolt.DB) error {
+	return src.View(func(tx *bolt.Tx) error {
+		return CopyTx(tx, dst)
+	})
+}
+
+// CopyTx writes all buckets and keys visible to src into dst, one transaction per top level bucket
+func CopyTx(src *bolt.Tx, dst *bolt.DB) error {
+	var names [][]byte
+	err := src.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		names = append(names, append([]byte(nil), name...))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		err := dst.Update(func(dtx *bolt.Tx) error {
+			// fill pages completely as keys are inserted in order
+			return walk([][]byte{name}, src.Bucket(name), func(path [][]byte, k, v []byte) error {
+				b, err := CreateBucketPath(dtx, path)
+				if err != nil {
+					return err
+				}
+				b.FillPercent = 1
+				if k == nil {
+					return nil
+				}
+				return b.Put(k, v)
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompactTx writes the contents visible to src without free pages into a new db file at path and returns its size
+//
+// A file left at path by an interrupted run is replaced.
+func CompactTx(src *bolt.Tx, path string, mode os.FileMode) (int64, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	dst, err := bolt.Open(path, mode, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return 0, err
+	}
+	if err := CopyTx(src, dst); err != nil {
+		dst.Close()
+		os.Remove(path)
+		return 0, err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(path)
+		return 0, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// Compact rewrites the db file at path without free pages
+//
+// The compacted copy is written next to path and renamed over it once complete.
+// The db must not be opened by anyone else while compacting.
+func Compact(path string) (before, after int64, err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	src, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+
+	tmp := path + ".compact"
+	err = src.View(func(tx *bolt.Tx) (err error) {
+		after, err = CompactTx(tx, tmp, info.Mode())
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := src.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, 0, err
+	}
+	return info.Size(), after, os.Rename(tmp, path)
+}
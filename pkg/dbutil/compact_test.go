@@ -0,0 +1,59 @@
+package dbutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+func TestCompactStaleFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-compact")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "insight.db")
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := CreateBucketPath(tx, [][]byte{[]byte("a"), []byte("b")})
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("k"), []byte("v"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	// left behind by an interrupted run
+	if err := ioutil.WriteFile(path+".compact", []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Compact(path); err != nil {
+		t.Fatal(err)
+	}
+	db, err = bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("a")).Bucket([]byte("b")).Get([]byte("k")); string(v) != "v" {
+			t.Errorf("got %q, want v", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("compact file left behind: %v", err)
+	}
+}
@@ -0,0 +1,194 @@
+package dbutil
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/boltdb/bolt"
+)
+
+// Entry is a single bucket or key/value pair of a dump
+//
+// Entries without a key describe a (possibly empty) bucket. If any of the
+// bucket names or the key is not printable text, all names are base64
+// encoded and NamesBase64 is set. The same applies to the value and ValueBase64.
+type Entry struct {
+	Bucket      []string `json:"bucket"`
+	Key         string   `json:"key,omitempty"`
+	Value       string   `json:"value,omitempty"`
+	NamesBase64 bool     `json:"namesBase64,omitempty"`
+	ValueBase64 bool     `json:"valueBase64,omitempty"`
+}
+
+// Walk calls fn for every bucket and every key/value pair in the db
+//
+// Buckets are passed with a nil key before their contents.
+func Walk(tx *bolt.Tx, fn func(path [][]byte, k, v []byte) error) error {
+	return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		return walk([][]byte{name}, b, fn)
+	})
+}
+
+func walk(path [][]byte, b *bolt.Bucket, fn func(path [][]byte, k, v []byte) error) error {
+	if err := fn(path, nil, nil); err != nil {
+		return err
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			sub := make([][]byte, len(path), len(path)+1)
+			copy(sub, path)
+			return walk(append(sub, k), b.Bucket(k), fn)
+		}
+		return fn(path, k, v)
+	})
+}
+
+// Dump writes the whole db as a json array of entries to w
+func Dump(db *bolt.DB, w io.Writer) error {
+	return db.View(func(tx *bolt.Tx) error {
+		if _, err := io.WriteString(w, "[\n"); err != nil {
+			return err
+		}
+		first := true
+		err := Walk(tx, func(path [][]byte, k, v []byte) error {
+			b, err := json.Marshal(newEntry(path, k, v))
+			if err != nil {
+				return err
+			}
+			if !first {
+				if _, err := io.WriteString(w, ",\n"); err != nil {
+					return err
+				}
+			}
+			first = false
+			_, err = w.Write(b)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, "\n]\n")
+		return err
+	})
+}
+
+func newEntry(path [][]byte, k, v []byte) Entry {
+	e := Entry{
+		NamesBase64: !printable(k),
+		ValueBase64: !printable(v),
+	}
+	for _, name := range path {
+		e.NamesBase64 = e.NamesBase64 || !printable(name)
+	}
+	e.Key = encode(k, e.NamesBase64)
+	e.Value = encode(v, e.ValueBase64)
+	for _, name := range path {
+		e.Bucket = append(e.Bucket, encode(name, e.NamesBase64))
+	}
+	return e
+}
+
+func encode(b []byte, b64 bool) string {
+	if b64 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return string(b)
+}
+
+func decode(s string, b64 bool) ([]byte, error) {
+	if b64 {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return []byte(s), nil
+}
+
+// decode returns the raw bucket path, key and value of e
+func (e Entry) decode() (path [][]byte, k, v []byte, err error) {
+	for _, name := range e.Bucket {
+		b, err := decode(name, e.NamesBase64)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		path = append(path, b)
+	}
+	if k, err = decode(e.Key, e.NamesBase64); err != nil {
+		return nil, nil, nil, err
+	}
+	if v, err = decode(e.Value, e.ValueBase64); err != nil {
+		return nil, nil, nil, err
+	}
+	return path, k, v, nil
+}
+
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Load reads a dump created by Dump from r and writes it to db in a single transaction
+func Load(db *bolt.DB, r io.Reader) (int, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return 0, err
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '[' {
+		return 0, errors.New("dump must be a json array")
+	}
+	var n int
+	err = db.Update(func(tx *bolt.Tx) error {
+		for dec.More() {
+			var e Entry
+			if err := dec.Decode(&e); err != nil {
+				return err
+			}
+			path, k, v, err := e.decode()
+			if err != nil {
+				return fmt.Errorf("entry %d: %v", n, err)
+			}
+			b, err := CreateBucketPath(tx, path)
+			if err != nil {
+				return fmt.Errorf("entry %d: %v", n, err)
+			}
+			if len(k) > 0 {
+				if err := b.Put(k, v); err != nil {
+					return fmt.Errorf("entry %d: %v", n, err)
+				}
+			}
+			n++
+		}
+		_, err := dec.Token()
+		return err
+	})
+	return n, err
+}
+
+// CreateBucketPath creates all nested buckets along path and returns the innermost one
+func CreateBucketPath(tx *bolt.Tx, path [][]byte) (*bolt.Bucket, error) {
+	if len(path) < 1 {
+		return nil, errors.New("empty bucket path")
+	}
+	b, err := tx.CreateBucketIfNotExists(path[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range path[1:] {
+		b, err = b.CreateBucketIfNotExists(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,121 @@
+package digest
+
+import (
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/seibert-media/inf-insight/pkg/insight"
+)
+
+// Report summarises the usage of all apps over one period
+type Report struct {
+	From          time.Time    `json:"from"`
+	To            time.Time    `json:"to"`
+	Total         int          `json:"total"`
+	PreviousTotal int          `json:"previousTotal"`
+	Change        *float64     `json:"change"`
+	Apps          []AppSummary `json:"apps"`
+	New           []string     `json:"new"`
+	Dormant       []string     `json:"dormant"`
+}
+
+// AppSummary holds the numbers of a single app for the reported period
+type AppSummary struct {
+	App      string        `json:"app"`
+	Total    int           `json:"total"`
+	Previous int           `json:"previous"`
+	Change   *float64      `json:"change"`
+	Types    []TypeSummary `json:"types"`
+}
+
+// TypeSummary holds the numbers of a single type for the reported period
+type TypeSummary struct {
+	Type     string   `json:"type"`
+	Total    int      `json:"total"`
+	Previous int      `json:"previous"`
+	Change   *float64 `json:"change"`
+}
+
+// Build summarises the period ending at to and compares it with the period right before
+//
+// History is kept per hour, so to is truncated to the full hour.
+// An app is considered new if all of its calls happened within the period
+// and dormant if it was used in the previous period but not in this one.
+func Build(db insight.DB, to time.Time, period time.Duration) (Report, error) {
+	to = to.Truncate(time.Hour)
+	from := to.Add(-period)
+	r := Report{
+		From:    from,
+		To:      to,
+		Apps:    []AppSummary{},
+		New:     []string{},
+		Dormant: []string{},
+	}
+	var all, current, previous insight.Totals
+	err := db.View(func(tx *bolt.Tx) (err error) {
+		all, err = insight.ReadTotals(tx)
+		if err != nil {
+			return err
+		}
+		current, err = insight.ReadHistory(tx, from, to)
+		if err != nil {
+			return err
+		}
+		previous, err = insight.ReadHistory(tx, from.Add(-period), from)
+		return err
+	})
+	if err != nil {
+		return r, err
+	}
+
+	for app, types := range current {
+		s := AppSummary{
+			App:      app,
+			Total:    current.Sum(app),
+			Previous: previous.Sum(app),
+		}
+		s.Change = change(s.Total, s.Previous)
+		for ctype, n := range types {
+			prev := previous[app][ctype]
+			s.Types = append(s.Types, TypeSummary{
+				Type:     ctype,
+				Total:    n,
+				Previous: prev,
+				Change:   change(n, prev),
+			})
+		}
+		sort.Slice(s.Types, func(i, j int) bool { return s.Types[i].Total > s.Types[j].Total })
+		r.Apps = append(r.Apps, s)
+		r.Total += s.Total
+		if all.Sum(app) <= s.Total {
+			r.New = append(r.New, app)
+		}
+	}
+	for app := range previous {
+		r.PreviousTotal += previous.Sum(app)
+		if current.Sum(app) == 0 {
+			r.Dormant = append(r.Dormant, app)
+		}
+	}
+	r.Change = change(r.Total, r.PreviousTotal)
+
+	sort.Slice(r.Apps, func(i, j int) bool {
+		if r.Apps[i].Total == r.Apps[j].Total {
+			return r.Apps[i].App < r.Apps[j].App
+		}
+		return r.Apps[i].Total > r.Apps[j].Total
+	})
+	sort.Strings(r.New)
+	sort.Strings(r.Dormant)
+	return r, nil
+}
+
+// change returns the relative change from previous to current in percent or nil if there is nothing to compare to
+func change(current, previous int) *float64 {
+	if previous == 0 {
+		return nil
+	}
+	c := float64(current-previous) / float64(previous) * 100
+	return &c
+}
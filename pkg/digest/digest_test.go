@@ -0,0 +1,212 @@
+package digest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/seibert-media/inf-insight/pkg/insight"
+)
+
+// testDb writes the given totals and hourly history, keyed by hour, into a fresh db
+func testDb(t *testing.T, totals insight.Totals, history map[time.Time]insight.Totals) (*bolt.DB, func()) {
+	dir, err := ioutil.TempDir("", "insight-digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(tx *bolt.Tx, totals insight.Totals, path ...[]byte) error {
+		for app, types := range totals {
+			b, err := tx.CreateBucketIfNotExists(path[0])
+			for _, name := range append(path[1:], []byte(app)) {
+				if err != nil {
+					return err
+				}
+				b, err = b.CreateBucketIfNotExists(name)
+			}
+			if err != nil {
+				return err
+			}
+			for ctype, n := range types {
+				if err := b.Put([]byte(ctype), insight.EncodeCount(uint64(n))); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := put(tx, totals, insight.AppsBucket); err != nil {
+			return err
+		}
+		for hour, h := range history {
+			if err := put(tx, h, insight.HistoryBucket, []byte(hour.UTC().Format(insight.HourLayout))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+var to = time.Date(2018, 1, 8, 10, 30, 0, 0, time.UTC)
+
+func testReport(t *testing.T) Report {
+	db, done := testDb(t,
+		insight.Totals{"deploytool": {"started": 10}, "fresh": {"started": 2}, "old": {"started": 4}},
+		map[time.Time]insight.Totals{
+			time.Date(2018, 1, 8, 5, 0, 0, 0, time.UTC): {"deploytool": {"started": 2, "stopped": 1}},
+			time.Date(2018, 1, 8, 6, 0, 0, 0, time.UTC): {"fresh": {"started": 2}},
+			// after the truncated end of the period
+			time.Date(2018, 1, 8, 10, 0, 0, 0, time.UTC): {"deploytool": {"started": 7}},
+			time.Date(2018, 1, 7, 5, 0, 0, 0, time.UTC):  {"deploytool": {"started": 1}},
+			time.Date(2018, 1, 7, 3, 0, 0, 0, time.UTC):  {"old": {"started": 4}},
+		})
+	defer done()
+	r, err := Build(db, to, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestBuild(t *testing.T) {
+	r := testReport(t)
+	if !r.To.Equal(time.Date(2018, 1, 8, 10, 0, 0, 0, time.UTC)) || !r.From.Equal(r.To.Add(-24*time.Hour)) {
+		t.Errorf("got period %v - %v", r.From, r.To)
+	}
+	if r.Total != 5 || r.PreviousTotal != 5 || r.Change == nil || *r.Change != 0 {
+		t.Errorf("got total %d, previous %d and change %v", r.Total, r.PreviousTotal, r.Change)
+	}
+	if len(r.Apps) != 2 || r.Apps[0].App != "deploytool" || r.Apps[1].App != "fresh" {
+		t.Fatalf("got apps %+v, want deploytool and fresh", r.Apps)
+	}
+	if a := r.Apps[0]; a.Total != 3 || a.Previous != 1 || a.Change == nil || *a.Change != 200 || a.Types[0].Type != "started" {
+		t.Errorf("got deploytool %+v", a)
+	}
+	if a := r.Apps[1]; a.Previous != 0 || a.Change != nil {
+		t.Errorf("got fresh %+v, want no change without previous calls", a)
+	}
+	if len(r.New) != 1 || r.New[0] != "fresh" || len(r.Dormant) != 1 || r.Dormant[0] != "old" {
+		t.Errorf("got new %v and dormant %v", r.New, r.Dormant)
+	}
+}
+
+func TestRender(t *testing.T) {
+	templates, err := NewTemplates("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := templates.Render(testReport(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# Usage digest 2018-01-07 - 2018-01-08",
+		"**5** calls in total (+0.0% compared to the previous period)",
+		"| deploytool | 3 | 1 | +200.0% |",
+		"| fresh | 2 | 0 | n/a |",
+		"**New apps:** fresh",
+		"**Dormant apps:** old",
+	} {
+		if !strings.Contains(string(out.Markdown), line) {
+			t.Errorf("markdown misses %q:\n%s", line, out.Markdown)
+		}
+	}
+	var r Report
+	if err := json.Unmarshal(out.JSON, &r); err != nil || r.Total != 5 || len(r.Apps) != 2 {
+		t.Errorf("got json report %+v, %v", r, err)
+	}
+}
+
+func TestCustomTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "digest.md")
+	if err := ioutil.WriteFile(path, []byte(`{{ join .New }} {{ change .Change }}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	templates, err := NewTemplates(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := templates.Render(testReport(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.Markdown) != "fresh +0.0%" {
+		t.Errorf("got %q", out.Markdown)
+	}
+	if _, err := NewTemplates(filepath.Join(dir, "missing.md"), ""); err == nil {
+		t.Error("got no error for a missing template")
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	var payload webhookPayload
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	out := Rendered{Markdown: []byte("# digest"), JSON: []byte(`{"total": 5}`)}
+	if err := (Webhook{URL: srv.URL}).Publish(out); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Text != "# digest" || string(payload.Report) != `{"total":5}` {
+		t.Errorf("got payload %q with report %s", payload.Text, payload.Report)
+	}
+
+	// reports of custom templates which are not json are sent as a string
+	out.JSON = []byte("not json")
+	if err := (Webhook{URL: srv.URL}).Publish(out); err != nil {
+		t.Fatal(err)
+	}
+	if string(payload.Report) != `"not json"` {
+		t.Errorf("got report %s, want a json string", payload.Report)
+	}
+
+	status = http.StatusBadGateway
+	if err := (Webhook{URL: srv.URL}).Publish(out); err == nil {
+		t.Error("got no error for a failing webhook")
+	}
+}
+
+func TestDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := Rendered{Report: Report{To: time.Date(2018, 1, 8, 10, 0, 0, 0, time.UTC)}, Markdown: []byte("md"), JSON: []byte("{}")}
+	if err := (Directory{Path: filepath.Join(dir, "digests")}).Publish(out); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"digest-2018-01-08T1000.md": "md", "digest-2018-01-08T1000.json": "{}"} {
+		b, err := ioutil.ReadFile(filepath.Join(dir, "digests", name))
+		if err != nil || string(b) != want {
+			t.Errorf("%s: got %q, %v, want %q", name, b, err, want)
+		}
+	}
+}
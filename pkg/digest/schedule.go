@@ -0,0 +1,72 @@
+package digest
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schedule returns the next point in time a report is due after t
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// every runs at a fixed interval
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// weekly runs once a week on a given weekday and time of day
+type weekly struct {
+	day    time.Weekday
+	hour   int
+	minute int
+}
+
+func (w weekly) Next(t time.Time) time.Time {
+	next := time.Date(t.Year(), t.Month(), t.Day(), w.hour, w.minute, 0, 0, t.Location())
+	next = next.AddDate(0, 0, (int(w.day)-int(next.Weekday())+7)%7)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// ParseSchedule parses either a duration like "24h" or a weekday with time of day like "mon 09:00"
+func ParseSchedule(s string) (Schedule, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 1 {
+		d, err := time.ParseDuration(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid schedule interval: %s", s)
+		}
+		return every(d), nil
+	}
+	if len(fields) != 2 || len(fields[0]) < 3 {
+		return nil, fmt.Errorf("invalid schedule: %s", s)
+	}
+	day, ok := weekdays[fields[0][:3]]
+	if !ok {
+		return nil, fmt.Errorf("invalid schedule weekday: %s", fields[0])
+	}
+	tod, err := time.Parse("15:04", fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule time: %s", fields[1])
+	}
+	return weekly{day: day, hour: tod.Hour(), minute: tod.Minute()}, nil
+}
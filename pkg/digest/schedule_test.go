@@ -0,0 +1,68 @@
+package digest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		s     string
+		valid bool
+	}{
+		{"24h", true},
+		{"mon 09:00", true},
+		{"Friday 17:30", true},
+		{"0s", false},
+		{"-1h", false},
+		{"mon", false},
+		{"xyz 09:00", false},
+		{"mon 25:00", false},
+		{"mon 09:00 utc", false},
+	}
+	for _, test := range tests {
+		if _, err := ParseSchedule(test.s); (err == nil) != test.valid {
+			t.Errorf("%q: got error %v, want valid %v", test.s, err, test.valid)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	at := func(loc *time.Location, day, hour, minute int) time.Time {
+		// January 2018 starts on a monday
+		return time.Date(2018, 1, day, hour, minute, 0, 0, loc)
+	}
+	monday, err := ParseSchedule("mon 09:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	daily, err := ParseSchedule("24h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		schedule Schedule
+		t, want  time.Time
+	}{
+		{"interval", daily, at(time.UTC, 1, 10, 30), at(time.UTC, 2, 10, 30)},
+		{"earlier the same day", monday, at(time.UTC, 1, 8, 0), at(time.UTC, 1, 9, 0)},
+		{"exactly due", monday, at(time.UTC, 1, 9, 0), at(time.UTC, 8, 9, 0)},
+		{"later the same day", monday, at(time.UTC, 1, 9, 1), at(time.UTC, 8, 9, 0)},
+		{"later in the week", monday, at(time.UTC, 6, 23, 59), at(time.UTC, 8, 9, 0)},
+		{"across the year", monday, at(time.UTC, 30, 12, 0), time.Date(2018, 2, 5, 9, 0, 0, 0, time.UTC)},
+	}
+	if berlin, err := time.LoadLocation("Europe/Berlin"); err == nil {
+		// summer time starts on sunday, 2018-03-25
+		tests = append(tests, struct {
+			name     string
+			schedule Schedule
+			t, want  time.Time
+		}{"across summer time", monday, time.Date(2018, 3, 19, 10, 0, 0, 0, berlin), time.Date(2018, 3, 26, 9, 0, 0, 0, berlin)})
+	}
+	for _, test := range tests {
+		if got := test.schedule.Next(test.t); !got.Equal(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
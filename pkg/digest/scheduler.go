@@ -0,0 +1,57 @@
+package digest
+
+import (
+	"time"
+
+	"github.com/seibert-media/inf-insight/pkg/insight"
+	"go.uber.org/zap"
+)
+
+// Scheduler generates reports on a schedule and hands them to all sinks
+type Scheduler struct {
+	Log       *zap.Logger
+	Db        insight.DB
+	Schedule  Schedule
+	Period    time.Duration
+	Templates *Templates
+	Sinks     []Sink
+}
+
+// Run blocks until done is closed and publishes a report every time the schedule is due
+func (s Scheduler) Run(done <-chan struct{}) {
+	for {
+		next := s.Schedule.Next(time.Now())
+		s.Log.Info("next digest scheduled", zap.Time("at", next))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			if err := s.Publish(now); err != nil {
+				s.Log.Error("digest error", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Publish builds the report for the period ending at to and sends it to all sinks
+func (s Scheduler) Publish(to time.Time) error {
+	r, err := Build(s.Db, to, s.Period)
+	if err != nil {
+		return err
+	}
+	out, err := s.Templates.Render(r)
+	if err != nil {
+		return err
+	}
+	var last error
+	for _, sink := range s.Sinks {
+		if err := sink.Publish(out); err != nil {
+			s.Log.Error("digest publish error", zap.Error(err))
+			last = err
+		}
+	}
+	s.Log.Info("published digest", zap.Time("from", r.From), zap.Time("to", r.To), zap.Int("apps", len(r.Apps)))
+	return last
+}
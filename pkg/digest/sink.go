@@ -0,0 +1,77 @@
+package digest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Sink publishes rendered reports
+type Sink interface {
+	Publish(r Rendered) error
+}
+
+// Webhook posts reports to a chat compatible webhook
+//
+// The payload carries the markdown report as text and the json report as report.
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+type webhookPayload struct {
+	Text   string          `json:"text"`
+	Report json.RawMessage `json:"report"`
+}
+
+// Publish implements Sink
+func (w Webhook) Publish(r Rendered) error {
+	report := json.RawMessage(r.JSON)
+	if !json.Valid(report) {
+		b, err := json.Marshal(string(r.JSON))
+		if err != nil {
+			return err
+		}
+		report = b
+	}
+	body, err := json.Marshal(webhookPayload{Text: string(r.Markdown), Report: report})
+	if err != nil {
+		return err
+	}
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Post(w.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Directory writes reports as markdown and json files into Path
+type Directory struct {
+	Path string
+}
+
+// Publish implements Sink
+func (d Directory) Publish(r Rendered) error {
+	if err := os.MkdirAll(d.Path, 0755); err != nil {
+		return err
+	}
+	base := filepath.Join(d.Path, "digest-"+r.Report.To.Format("2006-01-02T1504"))
+	if err := ioutil.WriteFile(base+".md", r.Markdown, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(base+".json", r.JSON, 0644)
+}
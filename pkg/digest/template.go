@@ -0,0 +1,109 @@
+package digest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"text/template"
+	"time"
+)
+
+// DefaultMarkdown is the builtin template for markdown reports
+const DefaultMarkdown = `# Usage digest {{ date .From }} - {{ date .To }}
+
+**{{ .Total }}** calls in total ({{ change .Change }} compared to the previous period)
+
+| App | Calls | Previous | Change |
+|-----|------:|---------:|-------:|
+{{- range .Apps }}
+| {{ .App }} | {{ .Total }} | {{ .Previous }} | {{ change .Change }} |
+{{- end }}
+{{ if .New }}
+**New apps:** {{ join .New }}
+{{ end }}{{ if .Dormant }}
+**Dormant apps:** {{ join .Dormant }}
+{{ end }}`
+
+// DefaultJSON is the builtin template for json reports
+const DefaultJSON = `{{ json . }}
+`
+
+// Templates renders reports in markdown and json
+type Templates struct {
+	Markdown *template.Template
+	JSON     *template.Template
+}
+
+var funcs = template.FuncMap{
+	"date": func(t time.Time) string {
+		return t.Format("2006-01-02")
+	},
+	"change": func(c *float64) string {
+		if c == nil {
+			return "n/a"
+		}
+		return fmt.Sprintf("%+.1f%%", *c)
+	},
+	"join": func(s []string) string {
+		var b bytes.Buffer
+		for i, v := range s {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(v)
+		}
+		return b.String()
+	},
+	"json": func(v interface{}) (string, error) {
+		b, err := json.MarshalIndent(v, "", "  ")
+		return string(b), err
+	},
+}
+
+// NewTemplates parses the templates at the given paths, empty paths fall back to the builtin templates
+func NewTemplates(markdownPath, jsonPath string) (*Templates, error) {
+	md, err := parse("markdown", markdownPath, DefaultMarkdown)
+	if err != nil {
+		return nil, err
+	}
+	js, err := parse("json", jsonPath, DefaultJSON)
+	if err != nil {
+		return nil, err
+	}
+	return &Templates{Markdown: md, JSON: js}, nil
+}
+
+func parse(name, path, def string) (*template.Template, error) {
+	text := def
+	if path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(b)
+	}
+	return template.New(name).Funcs(funcs).Parse(text)
+}
+
+// Rendered holds a report in all supported formats
+type Rendered struct {
+	Report   Report
+	Markdown []byte
+	JSON     []byte
+}
+
+// Render executes all templates for r
+func (t *Templates) Render(r Report) (Rendered, error) {
+	out := Rendered{Report: r}
+	var md, js bytes.Buffer
+	if err := t.Markdown.Execute(&md, r); err != nil {
+		return out, err
+	}
+	if err := t.JSON.Execute(&js, r); err != nil {
+		return out, err
+	}
+	out.Markdown = md.Bytes()
+	out.JSON = js.Bytes()
+	return out, nil
+}
@@ -0,0 +1,110 @@
+package insight
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AccessLog logs one line per request
+//
+// Only every Sample-th successful request is logged, failures always are.
+// With RequestIDHeader set, the request id from that header is kept or a new one generated,
+// echoed in the response header and added to the context of the request.
+type AccessLog struct {
+	Log             *zap.Logger
+	Sample          int
+	RequestIDHeader string
+
+	successes uint64
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the id assigned to a request by AccessLog, or "" if there is none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLog adds the id of a request to log
+func requestLog(log *zap.Logger, r *http.Request) *zap.Logger {
+	if id := RequestID(r.Context()); id != "" {
+		return log.With(zap.String("request_id", id))
+	}
+	return log
+}
+
+// Handler logs every request to h
+//
+// The route is taken from the routers the same way as by Instruments.Handler.
+func (a *AccessLog) Handler(h http.Handler, routers ...*mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		var id string
+		if a.RequestIDHeader != "" {
+			id = validRequestID(r.Header.Get(a.RequestIDHeader))
+			if id == "" {
+				id = newRequestID()
+			}
+			w.Header().Set(a.RequestIDHeader, id)
+			r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		}
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		defer func() {
+			if !a.sampled(sw.code) {
+				return
+			}
+			fields := []zapcore.Field{
+				zap.String("method", r.Method),
+				zap.String("path", r.URL.Path),
+				zap.String("route", route(r, routers)),
+				zap.Int("code", sw.code),
+				zap.Int("bytes", sw.bytes),
+				zap.Duration("took", time.Since(start)),
+				zap.String("remote", r.RemoteAddr),
+				zap.String("user_agent", r.UserAgent()),
+			}
+			if id != "" {
+				fields = append(fields, zap.String("request_id", id))
+			}
+			a.Log.Info("access", fields...)
+		}()
+		h.ServeHTTP(sw, r)
+	})
+}
+
+func (a *AccessLog) sampled(code int) bool {
+	if code >= http.StatusBadRequest || a.Sample <= 1 {
+		return true
+	}
+	return atomic.AddUint64(&a.successes, 1)%uint64(a.Sample) == 1
+}
+
+// validRequestID returns id if it is short and printable enough to be logged and echoed
+func validRequestID(id string) string {
+	if len(id) > 128 {
+		return ""
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return ""
+		}
+	}
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
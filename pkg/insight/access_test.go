@@ -0,0 +1,93 @@
+package insight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAccessLogSampled(t *testing.T) {
+	a := &AccessLog{Sample: 3}
+	tests := []struct {
+		code int
+		want bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusOK, false},
+		{http.StatusInternalServerError, true},
+		{http.StatusOK, false},
+		{http.StatusBadRequest, true},
+		{http.StatusOK, true},
+		{http.StatusOK, false},
+	}
+	for i, test := range tests {
+		if got := a.sampled(test.code); got != test.want {
+			t.Errorf("request %d with %d: got sampled %v, want %v", i, test.code, got, test.want)
+		}
+	}
+	if all := (&AccessLog{Sample: 1}); !all.sampled(http.StatusOK) || !all.sampled(http.StatusOK) {
+		t.Error("sample of 1 dropped a request")
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"abc-123", "abc-123"},
+		{strings.Repeat("a", 128), strings.Repeat("a", 128)},
+		{strings.Repeat("a", 129), ""},
+		{"with space", ""},
+		{"new\nline", ""},
+		{"ümlaut", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := validRequestID(test.id); got != test.want {
+			t.Errorf("%.20q: got %.20q, want %.20q", test.id, got, test.want)
+		}
+	}
+}
+
+func TestAccessLogRequestID(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	a := &AccessLog{Log: zap.New(core), RequestIDHeader: "X-Request-Id"}
+	var seen string
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestID(r.Context())
+	}))
+
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{"abc-123", true},
+		{"", false},
+		{"not valid", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if test.header != "" {
+			r.Header.Set("X-Request-Id", test.header)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		id := w.Header().Get("X-Request-Id")
+		if test.keep && id != test.header || !test.keep && len(id) != 32 {
+			t.Errorf("%q: got response id %q", test.header, id)
+		}
+		if seen != id {
+			t.Errorf("%q: got id %q in the context, want %q", test.header, seen, id)
+		}
+		entries := logs.TakeAll()
+		if len(entries) != 1 || entries[0].ContextMap()["request_id"] != id {
+			t.Errorf("%q: got log entries %v, want one with request id %q", test.header, entries, id)
+		}
+	}
+}
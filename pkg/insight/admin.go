@@ -0,0 +1,360 @@
+package insight
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.uber.org/zap"
+)
+
+// AdminRequest holds the parameters of all admin operations
+//
+// App limits type operations to a single app, all apps are affected if it is empty.
+type AdminRequest struct {
+	App  string `json:"app,omitempty"`
+	Type string `json:"type,omitempty"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// adminOp changes the stored counters of apps
+type adminOp struct {
+	apps  func(tx *bolt.Tx, req AdminRequest) ([]string, error)
+	apply func(tx *bolt.Tx, req AdminRequest) error
+}
+
+var adminOps = map[string]adminOp{
+	"merge-apps":  {mergedApps, mergeApps},
+	"delete-app":  {selectedApps, deleteApp},
+	"rename-type": {selectedApps, renameType},
+	"delete-type": {selectedApps, deleteType},
+}
+
+// AdminHandler returns a handler applying op to the stored history and the exported metrics
+//
+// Supported ops are "merge-apps", "delete-app", "rename-type" and "delete-type".
+// Every successful operation is recorded in the audit bucket.
+func AdminHandler(s Server, name string) http.HandlerFunc {
+	op, ok := adminOps[name]
+	if !ok {
+		panic("unknown admin op: " + name)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AdminRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var entry AuditEntry
+		var before, after Totals
+		var st SeenTimes
+		s.SeriesMu.Lock()
+		defer s.SeriesMu.Unlock()
+		err := s.Db.Update(func(tx *bolt.Tx) error {
+			apps, err := op.apps(tx, req)
+			if err != nil {
+				return err
+			}
+			if before, err = readApps(tx, apps); err != nil {
+				return err
+			}
+			if err := op.apply(tx, req); err != nil {
+				return err
+			}
+			if err := ResetCounts(tx); err != nil {
+				return err
+			}
+			if after, err = readApps(tx, apps); err != nil {
+				return err
+			}
+			entry = AuditEntry{
+				Time:    time.Now().UTC(),
+				Op:      name,
+				Request: req,
+				Remote:  r.RemoteAddr,
+				Apps:    apps,
+			}
+			if err := recordAudit(tx, &entry); err != nil {
+				return err
+			}
+			st, err = s.readSeen(tx)
+			return err
+		})
+		if err != nil {
+			s.Log.Error("admin op failed", zap.String("op", name), zap.Any("request", req), zap.Error(err))
+			status := http.StatusInternalServerError
+			if _, ok := err.(adminError); ok {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		// the series are only changed once the counts they export are committed
+		s.replaceSeries(before, after, st)
+		s.reloadSeen(st)
+		s.Log.Info("admin op applied", zap.String("op", name), zap.Any("request", req))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entry)
+	}
+}
+
+// adminError marks invalid admin requests
+type adminError string
+
+func (e adminError) Error() string { return string(e) }
+
+// mergedApps returns the source and target of a merge
+func mergedApps(tx *bolt.Tx, req AdminRequest) ([]string, error) {
+	return []string{req.From, req.To}, nil
+}
+
+// selectedApps returns App or all apps if it is empty
+func selectedApps(tx *bolt.Tx, req AdminRequest) ([]string, error) {
+	if req.App != "" {
+		return []string{req.App}, nil
+	}
+	var apps []string
+	root := tx.Bucket(AppsBucket)
+	if root == nil {
+		return nil, nil
+	}
+	err := root.ForEach(func(name, _ []byte) error {
+		apps = append(apps, string(name))
+		return nil
+	})
+	return apps, err
+}
+
+// readApps returns the all time counts of the given apps
+func readApps(tx *bolt.Tx, apps []string) (Totals, error) {
+	totals := make(Totals)
+	root := tx.Bucket(AppsBucket)
+	if root == nil {
+		return totals, nil
+	}
+	for _, app := range apps {
+		b := root.Bucket([]byte(app))
+		if b == nil {
+			continue
+		}
+		err := b.ForEach(func(k, v []byte) error {
+			n, err := DecodeCount(v)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %v", app, k, err)
+			}
+			totals.Add(app, string(k), int(n))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return totals, nil
+}
+
+// counterParents calls fn with every bucket holding app buckets, the totals and all hourly and daily buckets
+func counterParents(tx *bolt.Tx, fn func(parent *bolt.Bucket) error) error {
+	if root := tx.Bucket(AppsBucket); root != nil {
+		if err := fn(root); err != nil {
+			return err
+		}
+	}
+	for _, name := range [][]byte{HistoryBucket, DailyBucket} {
+		history := tx.Bucket(name)
+		if history == nil {
+			continue
+		}
+		err := history.ForEach(func(period, _ []byte) error {
+			if b := history.Bucket(period); b != nil {
+				return fn(b)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mergeApps(tx *bolt.Tx, req AdminRequest) error {
+	if req.From == "" || req.To == "" || req.From == req.To {
+		return adminError("merge-apps requires distinct from and to")
+	}
+	if root := tx.Bucket(AppsBucket); root == nil || root.Bucket([]byte(req.From)) == nil {
+		return adminError("unknown app: " + req.From)
+	}
+	from, to := []byte(req.From), []byte(req.To)
+	err := counterParents(tx, func(parent *bolt.Bucket) error {
+		src := parent.Bucket(from)
+		if src == nil {
+			return nil
+		}
+		dst, err := parent.CreateBucketIfNotExists(to)
+		if err != nil {
+			return err
+		}
+		err = src.ForEach(func(k, v []byte) error {
+			n, err := DecodeCount(v)
+			if err != nil {
+				return err
+			}
+			return incr(dst, clone(k), n)
+		})
+		if err != nil {
+			return err
+		}
+		return parent.DeleteBucket(from)
+	})
+	if err != nil {
+		return err
+	}
+	seen := tx.Bucket(SeenBucket)
+	if seen == nil || seen.Bucket(from) == nil {
+		return nil
+	}
+	dst, err := seen.CreateBucketIfNotExists(to)
+	if err != nil {
+		return err
+	}
+	err = seen.Bucket(from).ForEach(func(k, v []byte) error {
+		s, err := decodeSeen(v)
+		if err != nil {
+			return err
+		}
+		return mergeSeen(dst, clone(k), s)
+	})
+	if err != nil {
+		return err
+	}
+	return seen.DeleteBucket(from)
+}
+
+func deleteApp(tx *bolt.Tx, req AdminRequest) error {
+	if req.App == "" {
+		return adminError("delete-app requires app")
+	}
+	if root := tx.Bucket(AppsBucket); root == nil || root.Bucket([]byte(req.App)) == nil {
+		return adminError("unknown app: " + req.App)
+	}
+	err := counterParents(tx, func(parent *bolt.Bucket) error {
+		if parent.Bucket([]byte(req.App)) == nil {
+			return nil
+		}
+		return parent.DeleteBucket([]byte(req.App))
+	})
+	if err != nil {
+		return err
+	}
+	if seen := tx.Bucket(SeenBucket); seen != nil && seen.Bucket([]byte(req.App)) != nil {
+		return seen.DeleteBucket([]byte(req.App))
+	}
+	return nil
+}
+
+// typeBuckets calls fn with every bucket holding counters of the apps selected by req
+func typeBuckets(tx *bolt.Tx, req AdminRequest, fn func(b *bolt.Bucket) error) error {
+	apps, err := selectedApps(tx, req)
+	if err != nil {
+		return err
+	}
+	return counterParents(tx, func(parent *bolt.Bucket) error {
+		for _, app := range apps {
+			if b := parent.Bucket([]byte(app)); b != nil {
+				if err := fn(b); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// seenBuckets calls fn with the seen times bucket of every app selected by req
+func seenBuckets(tx *bolt.Tx, req AdminRequest, fn func(b *bolt.Bucket) error) error {
+	seen := tx.Bucket(SeenBucket)
+	if seen == nil {
+		return nil
+	}
+	apps, err := selectedApps(tx, req)
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		if b := seen.Bucket([]byte(app)); b != nil {
+			if err := fn(b); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func renameType(tx *bolt.Tx, req AdminRequest) error {
+	if req.From == "" || req.To == "" || req.From == req.To {
+		return adminError("rename-type requires distinct from and to")
+	}
+	from, to := []byte(req.From), []byte(req.To)
+	var found bool
+	err := typeBuckets(tx, req, func(b *bolt.Bucket) error {
+		v := b.Get(from)
+		if v == nil {
+			return nil
+		}
+		found = true
+		n, err := DecodeCount(v)
+		if err != nil {
+			return err
+		}
+		if err := incr(b, to, n); err != nil {
+			return err
+		}
+		return b.Delete(from)
+	})
+	if err == nil && !found {
+		return adminError("unknown type: " + req.From)
+	}
+	if err != nil {
+		return err
+	}
+	return seenBuckets(tx, req, func(b *bolt.Bucket) error {
+		v := b.Get(from)
+		if v == nil {
+			return nil
+		}
+		s, err := decodeSeen(v)
+		if err != nil {
+			return err
+		}
+		if err := mergeSeen(b, to, s); err != nil {
+			return err
+		}
+		return b.Delete(from)
+	})
+}
+
+func deleteType(tx *bolt.Tx, req AdminRequest) error {
+	if req.Type == "" {
+		return adminError("delete-type requires type")
+	}
+	var found bool
+	err := typeBuckets(tx, req, func(b *bolt.Bucket) error {
+		if b.Get([]byte(req.Type)) == nil {
+			return nil
+		}
+		found = true
+		return b.Delete([]byte(req.Type))
+	})
+	if err == nil && !found {
+		return adminError("unknown type: " + req.Type)
+	}
+	if err != nil {
+		return err
+	}
+	return seenBuckets(tx, req, func(b *bolt.Bucket) error {
+		return b.Delete([]byte(req.Type))
+	})
+}
@@ -0,0 +1,137 @@
+package insight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+func testServer(db DB) Server {
+	metric := DefaultMetricOpts()
+	vec := metric.NewCounterVec()
+	return Server{
+		Log:        zap.NewNop(),
+		Counter:    kitprometheus.NewCounter(vec),
+		CounterVec: vec,
+		Metric:     metric,
+		Db:         db,
+		SeriesMu:   &sync.Mutex{},
+	}
+}
+
+// exported returns the exported counts by type and app, separated by a slash
+func exported(t *testing.T, s Server) map[string]float64 {
+	ch := make(chan prometheus.Metric, 100)
+	s.CounterVec.Collect(ch)
+	close(ch)
+	m := make(map[string]float64)
+	for metric := range ch {
+		var d dto.Metric
+		if err := metric.Write(&d); err != nil {
+			t.Fatal(err)
+		}
+		labels := make(map[string]string)
+		for _, l := range d.Label {
+			labels[l.GetName()] = l.GetValue()
+		}
+		m[labels["type"]+"/"+labels["app"]] = d.Counter.GetValue()
+	}
+	return m
+}
+
+func TestAdminRenameType(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	s := testServer(db)
+	for _, ctype := range []string{"started", "started", "stopped"} {
+		if err := s.Count(ctype, "deploytool"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := AdminHandler(s, "rename-type")
+
+	tests := []struct {
+		body   string
+		status int
+		series map[string]float64
+	}{
+		{`{"from": "started", "to": "stopped"}`, http.StatusOK, map[string]float64{"stopped/deploytool": 3}},
+		{`{"from": "unknown", "to": "stopped"}`, http.StatusBadRequest, map[string]float64{"stopped/deploytool": 3}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodPost, "/admin/rename-type", strings.NewReader(test.body)))
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.body, w.Code, test.status, w.Body)
+		}
+		if got := exported(t, s); !reflect.DeepEqual(got, test.series) {
+			t.Errorf("%s: got series %v, want %v", test.body, got, test.series)
+		}
+	}
+}
+
+func TestAdminSeenTimes(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	s := testServer(db)
+	start := time.Date(2018, 1, 2, 15, 0, 0, 0, time.UTC)
+	calls := []struct {
+		at         time.Time
+		ctype, app string
+	}{
+		{start, "started", "deploytool"},
+		{start.Add(time.Hour), "stopped", "deploytool"},
+		{start.Add(-time.Hour), "started", "deploy-tool"},
+		{start.Add(2 * time.Hour), "started", "deploy-tool"},
+	}
+	for _, c := range calls {
+		err := db.Update(func(tx *bolt.Tx) error {
+			_, _, err := recordSeen(tx, c.at, c.ctype, c.app)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		put(t, db, string(EncodeCount(1)), string(AppsBucket), c.app, c.ctype)
+	}
+
+	tests := []struct {
+		op, body string
+		want     SeenTimes
+	}{
+		{"merge-apps", `{"from": "deploy-tool", "to": "deploytool"}`, SeenTimes{"deploytool": {
+			"started": {First: start.Add(-time.Hour), Last: start.Add(2 * time.Hour)},
+			"stopped": {First: start.Add(time.Hour), Last: start.Add(time.Hour)},
+		}}},
+		{"rename-type", `{"from": "stopped", "to": "started"}`, SeenTimes{"deploytool": {
+			"started": {First: start.Add(-time.Hour), Last: start.Add(2 * time.Hour)},
+		}}},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		AdminHandler(s, test.op)(w, httptest.NewRequest(http.MethodPost, "/admin/"+test.op, strings.NewReader(test.body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d: %s", test.op, w.Code, w.Body)
+		}
+		err := db.View(func(tx *bolt.Tx) error {
+			st, err := ReadSeen(tx)
+			if !reflect.DeepEqual(st, test.want) {
+				t.Errorf("%s: got seen times %v, want %v", test.op, st, test.want)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
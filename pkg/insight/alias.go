@@ -0,0 +1,354 @@
+package insight
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.uber.org/zap"
+)
+
+// Alias buckets, both hold one nested bucket per kind
+var (
+	AliasBucket     = []byte("insight:aliases")
+	AliasHitsBucket = []byte("insight:alias_hits")
+)
+
+// Alias kinds
+const (
+	AliasApp  = "app"
+	AliasType = "type"
+)
+
+// AliasTable maps legacy names to their canonical names per kind
+type AliasTable map[string]map[string]string
+
+// LoadAliasTable reads a json alias table like {"app": {"deploy-tool": "deploytool"}, "type": {}}
+func LoadAliasTable(path string) (AliasTable, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var t AliasTable
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, err
+	}
+	return t, t.validate()
+}
+
+func (t AliasTable) validate() error {
+	for kind, aliases := range t {
+		if kind != AliasApp && kind != AliasType {
+			return fmt.Errorf("unknown alias kind: %s", kind)
+		}
+		for alias, canonical := range aliases {
+			if alias == "" || canonical == "" || alias == canonical {
+				return fmt.Errorf("invalid %s alias: %q -> %q", kind, alias, canonical)
+			}
+			if _, ok := aliases[canonical]; ok {
+				return fmt.Errorf("alias target is an alias itself: %s", canonical)
+			}
+		}
+	}
+	return nil
+}
+
+// Aliases resolves app and type aliases from the config and from the db
+//
+// Aliases from the config take precedence and can not be changed through the api.
+type Aliases struct {
+	mu     sync.RWMutex
+	config AliasTable
+	stored AliasTable
+}
+
+// NewAliases creates the alias resolver and loads the aliases stored in db
+//
+// It fails if the target of an alias from the config or the db is itself an alias.
+func NewAliases(db DB, config AliasTable) (*Aliases, error) {
+	a := &Aliases{config: config, stored: AliasTable{}}
+	if a.config == nil {
+		a.config = AliasTable{}
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		root := tx.Bucket(AliasBucket)
+		if root == nil {
+			return nil
+		}
+		return root.ForEach(func(kind, _ []byte) error {
+			b := root.Bucket(kind)
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(alias, canonical []byte) error {
+				a.set(a.stored, string(kind), string(alias), string(canonical))
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return a, err
+	}
+	return a, checkChains(a.config, a.stored)
+}
+
+// SetConfig replaces the aliases from the config, unless a target of the new or the stored aliases would be an alias itself
+func (a *Aliases) SetConfig(config AliasTable) error {
+	if config == nil {
+		config = AliasTable{}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := checkChains(config, a.stored); err != nil {
+		return err
+	}
+	a.config = config
+	return nil
+}
+
+// checkChains returns an error if a target is itself an alias, as Resolve only follows one alias
+//
+// Stored aliases which are shadowed by the config are ignored, like in lookup.
+func checkChains(config, stored AliasTable) error {
+	for _, kind := range []string{AliasApp, AliasType} {
+		aliases := make(map[string]string)
+		for alias, canonical := range stored[kind] {
+			aliases[alias] = canonical
+		}
+		for alias, canonical := range config[kind] {
+			aliases[alias] = canonical
+		}
+		for alias, canonical := range aliases {
+			if next, ok := aliases[canonical]; ok {
+				return fmt.Errorf("%s alias target is an alias itself: %s -> %s -> %s", kind, alias, canonical, next)
+			}
+		}
+	}
+	return nil
+}
+
+func (a *Aliases) set(t AliasTable, kind, alias, canonical string) {
+	if t[kind] == nil {
+		t[kind] = make(map[string]string)
+	}
+	t[kind][alias] = canonical
+}
+
+func (a *Aliases) lookup(kind, name string) (string, bool) {
+	if canonical, ok := a.config[kind][name]; ok {
+		return canonical, true
+	}
+	canonical, ok := a.stored[kind][name]
+	return canonical, ok
+}
+
+// AliasHit records that an alias has been resolved
+type AliasHit struct {
+	Kind  string
+	Alias string
+}
+
+// Resolve returns the canonical app and type names and the aliases which have been applied
+func (a *Aliases) Resolve(app, ctype string) (string, string, []AliasHit) {
+	if a == nil {
+		return app, ctype, nil
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var hits []AliasHit
+	if canonical, ok := a.lookup(AliasApp, app); ok {
+		hits = append(hits, AliasHit{AliasApp, app})
+		app = canonical
+	}
+	if canonical, ok := a.lookup(AliasType, ctype); ok {
+		hits = append(hits, AliasHit{AliasType, ctype})
+		ctype = canonical
+	}
+	return app, ctype, hits
+}
+
+// recordAliasHits increments the hit counter and last hit time of all hits
+func recordAliasHits(tx *bolt.Tx, t time.Time, hits []AliasHit) error {
+	for _, hit := range hits {
+		b, err := bucketPath(tx, AliasHitsBucket, []byte(hit.Kind))
+		if err != nil {
+			return err
+		}
+		n, _ := decodeAliasStats(b.Get([]byte(hit.Alias)))
+		if err := b.Put([]byte(hit.Alias), encodeAliasStats(n+1, t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alias stats are stored as hit count followed by the unix time of the last hit
+func encodeAliasStats(hits uint64, last time.Time) []byte {
+	b := make([]byte, 2*counterSize)
+	binary.BigEndian.PutUint64(b, hits)
+	binary.BigEndian.PutUint64(b[counterSize:], uint64(last.Unix()))
+	return b
+}
+
+func decodeAliasStats(b []byte) (uint64, time.Time) {
+	if len(b) != 2*counterSize {
+		return 0, time.Time{}
+	}
+	return binary.BigEndian.Uint64(b), time.Unix(int64(binary.BigEndian.Uint64(b[counterSize:])), 0).UTC()
+}
+
+// AliasInfo describes an alias and its usage
+type AliasInfo struct {
+	Kind      string     `json:"kind"`
+	Alias     string     `json:"alias"`
+	Canonical string     `json:"canonical"`
+	Source    string     `json:"source"`
+	Hits      uint64     `json:"hits"`
+	LastHit   *time.Time `json:"lastHit"`
+}
+
+// List returns all aliases with their hit counts
+func (a *Aliases) List(tx *bolt.Tx) []AliasInfo {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var infos []AliasInfo
+	for source, t := range map[string]AliasTable{"config": a.config, "db": a.stored} {
+		for kind, aliases := range t {
+			for alias, canonical := range aliases {
+				if source == "db" {
+					if _, ok := a.config[kind][alias]; ok {
+						continue
+					}
+				}
+				info := AliasInfo{Kind: kind, Alias: alias, Canonical: canonical, Source: source}
+				if b := tx.Bucket(AliasHitsBucket); b != nil {
+					if kb := b.Bucket([]byte(kind)); kb != nil {
+						var last time.Time
+						info.Hits, last = decodeAliasStats(kb.Get([]byte(alias)))
+						if info.Hits > 0 {
+							info.LastHit = &last
+						}
+					}
+				}
+				infos = append(infos, info)
+			}
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Kind != infos[j].Kind {
+			return infos[i].Kind < infos[j].Kind
+		}
+		return infos[i].Alias < infos[j].Alias
+	})
+	return infos
+}
+
+// AliasRequest sets or removes a stored alias
+type AliasRequest struct {
+	Kind      string `json:"kind"`
+	Alias     string `json:"alias"`
+	Canonical string `json:"canonical,omitempty"`
+}
+
+// AliasesHandler lists all aliases and their traffic
+func AliasesHandler(s Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		infos := []AliasInfo{}
+		s.Db.View(func(tx *bolt.Tx) error {
+			infos = append(infos, s.Aliases.List(tx)...)
+			return nil
+		})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(infos)
+	}
+}
+
+// SetAliasHandler stores an alias, or removes it if remove is set
+func SetAliasHandler(s Server, remove bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AliasRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err := s.Aliases.update(s.Db, req, remove, r.RemoteAddr)
+		if err != nil {
+			s.Log.Error("alias update failed", zap.Any("request", req), zap.Error(err))
+			status := http.StatusInternalServerError
+			if _, ok := err.(adminError); ok {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		s.Log.Info("alias updated", zap.Any("request", req), zap.Bool("removed", remove))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// update validates req and applies it to the db and the in memory table
+func (a *Aliases) update(db DB, req AliasRequest, remove bool, remote string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if req.Kind != AliasApp && req.Kind != AliasType {
+		return adminError("unknown alias kind: " + req.Kind)
+	}
+	if _, ok := a.config[req.Kind][req.Alias]; ok {
+		return adminError("alias is defined in the config: " + req.Alias)
+	}
+	op := "remove-" + req.Kind + "-alias"
+	if !remove {
+		op = "set-" + req.Kind + "-alias"
+		if req.Alias == "" || req.Canonical == "" || req.Alias == req.Canonical {
+			return adminError("alias and distinct canonical are required")
+		}
+		if _, ok := a.lookup(req.Kind, req.Canonical); ok {
+			return adminError("alias target is an alias itself: " + req.Canonical)
+		}
+		for _, table := range []AliasTable{a.config, a.stored} {
+			for alias, canonical := range table[req.Kind] {
+				if canonical == req.Alias {
+					return adminError("alias is the target of " + alias)
+				}
+			}
+		}
+	} else if _, ok := a.stored[req.Kind][req.Alias]; !ok {
+		return adminError("unknown alias: " + req.Alias)
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := bucketPath(tx, AliasBucket, []byte(req.Kind))
+		if err != nil {
+			return err
+		}
+		if remove {
+			err = b.Delete([]byte(req.Alias))
+		} else {
+			err = b.Put([]byte(req.Alias), []byte(req.Canonical))
+		}
+		if err != nil {
+			return err
+		}
+		return recordAudit(tx, &AuditEntry{
+			Time:    time.Now().UTC(),
+			Op:      op,
+			Request: AdminRequest{From: req.Alias, To: req.Canonical},
+			Remote:  remote,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if remove {
+		delete(a.stored[req.Kind], req.Alias)
+	} else {
+		a.set(a.stored, req.Kind, req.Alias, req.Canonical)
+	}
+	return nil
+}
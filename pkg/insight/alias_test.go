@@ -0,0 +1,74 @@
+package insight
+
+import "testing"
+
+func TestAliasUpdateChains(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	a, err := NewAliases(db, AliasTable{AliasApp: {"deploy-tool": "deploytool"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.update(db, AliasRequest{Kind: AliasApp, Alias: "dt", Canonical: "deploy"}, false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		req  AliasRequest
+	}{
+		{"config alias", AliasRequest{Kind: AliasApp, Alias: "deploy-tool", Canonical: "other"}},
+		{"target is a config alias", AliasRequest{Kind: AliasApp, Alias: "x", Canonical: "deploy-tool"}},
+		{"target is a stored alias", AliasRequest{Kind: AliasApp, Alias: "x", Canonical: "dt"}},
+		{"alias is a config target", AliasRequest{Kind: AliasApp, Alias: "deploytool", Canonical: "other"}},
+		{"alias is a stored target", AliasRequest{Kind: AliasApp, Alias: "deploy", Canonical: "other"}},
+	}
+	for _, test := range tests {
+		err := a.update(db, test.req, false, "")
+		if _, ok := err.(adminError); !ok {
+			t.Errorf("%s: got %v, want a rejection", test.name, err)
+		}
+	}
+	if app, _, _ := a.Resolve("dt", "started"); app != "deploy" {
+		t.Errorf("resolved dt to %s, want deploy", app)
+	}
+}
+
+func TestAliasConfigChains(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	a, err := NewAliases(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.update(db, AliasRequest{Kind: AliasApp, Alias: "dt", Canonical: "deploy"}, false, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		config AliasTable
+	}{
+		{"target is a stored alias", AliasTable{AliasApp: {"deploy-tool": "dt"}}},
+		{"alias is a stored target", AliasTable{AliasApp: {"deploy": "other"}}},
+		{"chain within the config", AliasTable{AliasType: {"a": "b", "b": "c"}}},
+	}
+	for _, test := range tests {
+		if _, err := NewAliases(db, test.config); err == nil {
+			t.Errorf("%s: got no error from NewAliases", test.name)
+		}
+		if err := a.SetConfig(test.config); err == nil {
+			t.Errorf("%s: got no error from SetConfig", test.name)
+		}
+	}
+	if app, _, _ := a.Resolve("deploy", "started"); app != "deploy" {
+		t.Errorf("resolved deploy to %s after rejected configs", app)
+	}
+	// a config alias shadowing a stored one may point elsewhere
+	if err := a.SetConfig(AliasTable{AliasApp: {"dt": "deploytool"}}); err != nil {
+		t.Fatal(err)
+	}
+	if app, _, _ := a.Resolve("dt", "started"); app != "deploytool" {
+		t.Errorf("resolved dt to %s, want deploytool", app)
+	}
+}
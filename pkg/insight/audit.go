@@ -0,0 +1,76 @@
+package insight
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// AuditBucket records all admin operations
+var AuditBucket = []byte("insight:audit")
+
+// AuditEntry describes a single admin operation
+type AuditEntry struct {
+	ID      uint64       `json:"id"`
+	Time    time.Time    `json:"time"`
+	Op      string       `json:"op"`
+	Request AdminRequest `json:"request"`
+	Remote  string       `json:"remote"`
+	Apps    []string     `json:"apps"`
+}
+
+// recordAudit appends e to the audit bucket, keyed by a sequence number
+func recordAudit(tx *bolt.Tx, e *AuditEntry) error {
+	b, err := tx.CreateBucketIfNotExists(AuditBucket)
+	if err != nil {
+		return err
+	}
+	e.ID, err = b.NextSequence()
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.Put(EncodeCount(e.ID), v)
+}
+
+// AuditHandler lists the newest audit entries, limited by the limit query parameter (default 100)
+func AuditHandler(s Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if l := r.URL.Query().Get("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil || limit < 1 {
+				http.Error(w, "invalid limit: "+l, http.StatusBadRequest)
+				return
+			}
+		}
+		entries := []AuditEntry{}
+		err := s.Db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket(AuditBucket)
+			if b == nil {
+				return nil
+			}
+			c := b.Cursor()
+			for k, v := c.Last(); k != nil && len(entries) < limit; k, v = c.Prev() {
+				var e AuditEntry
+				if err := json.Unmarshal(v, &e); err != nil {
+					return err
+				}
+				entries = append(entries, e)
+			}
+			return nil
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(entries)
+	}
+}
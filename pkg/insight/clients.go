@@ -0,0 +1,57 @@
+package insight
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+)
+
+// AllApps allows a client to report for every app
+const AllApps = "*"
+
+// ClientApps maps client certificate identities to the apps they may report for
+//
+// Identities are the common name and all DNS, email and URI subject alternative names.
+type ClientApps map[string][]string
+
+// LoadClientApps reads a json mapping like {"ci.example.com": ["deploytool"], "admin@example.com": ["*"]}
+func LoadClientApps(path string) (ClientApps, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c ClientApps
+	return c, json.Unmarshal(b, &c)
+}
+
+// Identities returns the names a client certificate identifies its owner with
+func Identities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return ids
+}
+
+// allows reports whether the verified client certificate of r may report for app
+//
+// Requests without a verified certificate are never allowed.
+func (c ClientApps) allows(r *http.Request, app string) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	for _, id := range Identities(r.TLS.VerifiedChains[0][0]) {
+		for _, allowed := range c[id] {
+			if allowed == AllApps || allowed == app {
+				return true
+			}
+		}
+	}
+	return false
+}
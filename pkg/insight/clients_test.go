@@ -0,0 +1,60 @@
+package insight
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestClientAppsAllows(t *testing.T) {
+	c := ClientApps{
+		"ci":                    {"deploytool"},
+		"build.example.com":     {"builder", "tester"},
+		"spiffe://example/jobs": {"jobs"},
+		"admin@example.com":     {AllApps},
+	}
+	spiffe, _ := url.Parse("spiffe://example/jobs")
+	certs := map[string]*x509.Certificate{
+		"cn":    {Subject: pkix.Name{CommonName: "ci"}},
+		"dns":   {Subject: pkix.Name{CommonName: "other"}, DNSNames: []string{"build.example.com"}},
+		"uri":   {URIs: []*url.URL{spiffe}},
+		"email": {EmailAddresses: []string{"admin@example.com"}},
+	}
+	request := func(cert string, verified bool) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/add", nil)
+		if cert == "" {
+			r.TLS = nil
+			return r
+		}
+		r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certs[cert]}}
+		if verified {
+			r.TLS.VerifiedChains = [][]*x509.Certificate{{certs[cert]}}
+		}
+		return r
+	}
+
+	tests := []struct {
+		name     string
+		cert     string
+		verified bool
+		app      string
+		want     bool
+	}{
+		{"common name", "cn", true, "deploytool", true},
+		{"common name other app", "cn", true, "builder", false},
+		{"dns name", "dns", true, "tester", true},
+		{"uri", "uri", true, "jobs", true},
+		{"wildcard", "email", true, "anything", true},
+		{"unverified", "email", false, "anything", false},
+		{"no tls", "", false, "deploytool", false},
+	}
+	for _, test := range tests {
+		if got := c.allows(request(test.cert, test.verified), test.app); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
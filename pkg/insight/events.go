@@ -0,0 +1,64 @@
+package insight
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// EventsBucket holds the raw event log
+//
+// Keys are the big endian unix nano timestamp followed by a sequence number,
+// so a cursor walks the events in the order they have been received.
+var EventsBucket = []byte("insight:events")
+
+// Event is a single call as it has been received, before aliases have been applied
+//
+// Labels are stored as sent and not aggregated anywhere.
+type Event struct {
+	Time   time.Time         `json:"time"`
+	App    string            `json:"app"`
+	Type   string            `json:"type"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// EventKey returns the smallest key of all events at or after t
+func EventKey(t time.Time, seq uint64) []byte {
+	k := make([]byte, 2*counterSize)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(k[counterSize:], seq)
+	return k
+}
+
+func appendEvent(tx *bolt.Tx, e Event) error {
+	b, err := tx.CreateBucketIfNotExists(EventsBucket)
+	if err != nil {
+		return err
+	}
+	seq, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.Put(EventKey(e.Time, seq), v)
+}
+
+// ReadEvents calls fn for every logged event in the order they have been received
+func ReadEvents(tx *bolt.Tx, fn func(e Event) error) error {
+	b := tx.Bucket(EventsBucket)
+	if b == nil {
+		return nil
+	}
+	return b.ForEach(func(_, v []byte) error {
+		var e Event
+		if err := json.Unmarshal(v, &e); err != nil {
+			return err
+		}
+		return fn(e)
+	})
+}
@@ -0,0 +1,115 @@
+package insight
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var healthKey = []byte("health_check")
+
+// writeCheckInterval limits how often the readiness probe writes to the db, probes in between only read
+const writeCheckInterval = 30 * time.Second
+
+// Health tracks whether the server is ready to count calls
+//
+// It is not ready until the previous metrics have been restored and again once draining begins.
+// While draining calls are still served, only the readiness probe fails.
+type Health struct {
+	Db       DB
+	ready    int32
+	draining int32
+
+	mu      sync.Mutex
+	written time.Time
+}
+
+// SetReady marks the server as ready or not ready
+func (h *Health) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&h.ready, v)
+}
+
+// Ready reports whether the server is ready
+func (h *Health) Ready() bool {
+	return atomic.LoadInt32(&h.ready) == 1
+}
+
+// Drain makes the readiness probe fail while calls are still served
+func (h *Health) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+func (h *Health) isDraining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
+// LivenessHandler reports whether the db is open and readable
+func (h *Health) LivenessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := h.Db.View(func(tx *bolt.Tx) error {
+			_, err := SchemaVersion(tx)
+			return err
+		})
+		if err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// ReadinessHandler reports whether the server is ready and the db is writable
+func (h *Health) ReadinessHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.Ready() || h.isDraining() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		if err := h.checkDb(time.Now()); err != nil {
+			http.Error(w, "db error: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// checkDb writes to the db if the last write is older than writeCheckInterval and reads from it otherwise
+func (h *Health) checkDb(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if now.Sub(h.written) < writeCheckInterval {
+		return h.Db.View(func(tx *bolt.Tx) error {
+			_, err := SchemaVersion(tx)
+			return err
+		})
+	}
+	err := h.Db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(MetaBucket)
+		if err != nil {
+			return err
+		}
+		return b.Put(healthKey, EncodeCount(uint64(now.Unix())))
+	})
+	if err == nil {
+		h.written = now
+	}
+	return err
+}
+
+// Gate responds with 503 to all requests until the server is ready
+func (h *Health) Gate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,55 @@
+package insight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// countingDB counts the write transactions of a DB
+type countingDB struct {
+	DB
+	updates int
+}
+
+func (c *countingDB) Update(fn func(*bolt.Tx) error) error {
+	c.updates++
+	return c.DB.Update(fn)
+}
+
+func TestReadinessWrites(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	counting := &countingDB{DB: db}
+	h := &Health{Db: counting}
+	probe := func() int {
+		w := httptest.NewRecorder()
+		h.ReadinessHandler()(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("got %d before ready, want 503", code)
+	}
+	h.SetReady(true)
+	for i := 0; i < 3; i++ {
+		if code := probe(); code != http.StatusOK {
+			t.Errorf("got %d when ready, want 200", code)
+		}
+	}
+	if counting.updates != 1 {
+		t.Errorf("got %d writes for 3 probes, want 1", counting.updates)
+	}
+	h.written = time.Now().Add(-writeCheckInterval)
+	probe()
+	if counting.updates != 2 {
+		t.Errorf("got %d writes, want another one after %s", counting.updates, writeCheckInterval)
+	}
+	h.Drain()
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("got %d while draining, want 503", code)
+	}
+}
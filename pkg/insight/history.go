@@ -0,0 +1,106 @@
+package insight
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// HourLayout is the time layout used for the keys of the hourly history buckets
+const HourLayout = "2006010215"
+
+// Totals maps app names to their per type counts
+type Totals map[string]map[string]int
+
+// Add increments the count of type for app by n
+func (t Totals) Add(app, ctype string, n int) {
+	types, ok := t[app]
+	if !ok {
+		types = make(map[string]int)
+		t[app] = types
+	}
+	types[ctype] += n
+}
+
+// Sum returns the count of all types for app
+func (t Totals) Sum(app string) int {
+	var sum int
+	for _, n := range t[app] {
+		sum += n
+	}
+	return sum
+}
+
+// recordHistory increments the hourly history counter of type for app at t
+func recordHistory(tx *bolt.Tx, t time.Time, ctype, app string) error {
+	b, err := bucketPath(tx, HistoryBucket, []byte(t.UTC().Format(HourLayout)), []byte(app))
+	if err != nil {
+		return err
+	}
+	return incr(b, []byte(ctype), 1)
+}
+
+// ReadTotals returns the all time counts of every app
+func ReadTotals(tx *bolt.Tx) (Totals, error) {
+	apps, err := selectedApps(tx, AdminRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return readApps(tx, apps)
+}
+
+// ReadHistory returns the counts recorded between from (inclusive) and to (exclusive)
+//
+// History which has been rolled up per day is included if the day starts within the range.
+func ReadHistory(tx *bolt.Tx, from, to time.Time) (Totals, error) {
+	totals := make(Totals)
+	err := readHistory(tx.Bucket(HistoryBucket), HourLayout, from, to, totals)
+	if err != nil {
+		return nil, err
+	}
+	day := from.UTC().Truncate(24 * time.Hour)
+	if day.Before(from) {
+		day = day.Add(24 * time.Hour)
+	}
+	err = readHistory(tx.Bucket(DailyBucket), DayLayout, day, to, totals)
+	if err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// readHistory adds the counts of all buckets in root with keys between from and to to totals
+func readHistory(root *bolt.Bucket, layout string, from, to time.Time, totals Totals) error {
+	if root == nil {
+		return nil
+	}
+	min := []byte(from.UTC().Format(layout))
+	max := []byte(to.UTC().Format(layout))
+	c := root.Cursor()
+	for k, _ := c.Seek(min); k != nil && bytes.Compare(k, max) < 0; k, _ = c.Next() {
+		period := root.Bucket(k)
+		if period == nil {
+			continue
+		}
+		err := period.ForEach(func(app, _ []byte) error {
+			b := period.Bucket(app)
+			if b == nil {
+				return nil
+			}
+			return b.ForEach(func(ctype, v []byte) error {
+				n, err := DecodeCount(v)
+				if err != nil {
+					return fmt.Errorf("%s/%s/%s: %v", k, app, ctype, err)
+				}
+				totals.Add(string(app), string(ctype), int(n))
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package insight
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Failure reasons of rejected calls
+const (
+	FailureDecode     = "decode"
+	FailureValidation = "validation"
+	FailureForbidden  = "forbidden"
+)
+
+// Instruments holds the metrics insight exports about itself
+//
+// All of them share the namespace, subsystem and constant labels of the call counter.
+type Instruments struct {
+	Requests *prometheus.HistogramVec
+	Count    prometheus.Histogram
+	Tx       *prometheus.HistogramVec
+	Failures *prometheus.CounterVec
+	Limited  *prometheus.CounterVec
+}
+
+// NewInstruments creates the self instrumentation metrics named after o
+func NewInstruments(o MetricOpts) *Instruments {
+	return &Instruments{
+		Requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   o.Subsystem,
+			Name:        "http_request_duration_seconds",
+			Help:        "duration of http requests per route, method and status code",
+			ConstLabels: o.ConstLabels,
+		}, []string{"route", "method", "code"}),
+		Count: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   o.Subsystem,
+			Name:        "count_duration_seconds",
+			Help:        "duration of counting a single call",
+			ConstLabels: o.ConstLabels,
+		}),
+		Tx: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   o.Subsystem,
+			Name:        "db_transaction_duration_seconds",
+			Help:        "duration of bolt transactions per kind",
+			ConstLabels: o.ConstLabels,
+		}, []string{"kind"}),
+		Failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   o.Subsystem,
+			Name:        "rejected_calls_total",
+			Help:        "calls which could not be counted per reason",
+			ConstLabels: o.ConstLabels,
+		}, []string{"reason"}),
+		Limited: newLimitedCounter(o),
+	}
+}
+
+// Collectors returns all metrics for registration
+func (i *Instruments) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{i.Requests, i.Count, i.Tx, i.Failures, i.Limited}
+}
+
+func (i *Instruments) observeCount(start time.Time) {
+	if i != nil {
+		i.Count.Observe(time.Since(start).Seconds())
+	}
+}
+
+func (i *Instruments) fail(reason string) {
+	if i != nil {
+		i.Failures.WithLabelValues(reason).Inc()
+	}
+}
+
+// Handler records the duration of every request to h
+//
+// The route label is the path template of the first router matching the request,
+// requests no router matches are recorded as "unmatched".
+func (i *Instruments) Handler(h http.Handler, routers ...*mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, code: http.StatusOK}
+		defer func() {
+			i.Requests.WithLabelValues(route(r, routers), r.Method, strconv.Itoa(sw.code)).Observe(time.Since(start).Seconds())
+		}()
+		h.ServeHTTP(sw, r)
+	})
+}
+
+func route(r *http.Request, routers []*mux.Router) string {
+	for _, router := range routers {
+		var match mux.RouteMatch
+		if router.Match(r, &match) && match.Route != nil {
+			if tpl, err := match.Route.GetPathTemplate(); err == nil {
+				return tpl
+			}
+		}
+	}
+	return "unmatched"
+}
+
+// statusWriter remembers the status code and number of bytes written to a response
+type statusWriter struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// StoreCollector exports the bolt statistics and file size of a Store
+type StoreCollector struct {
+	store *Store
+	descs map[string]*prometheus.Desc
+}
+
+// NewStoreCollector creates a collector for s named after o
+func NewStoreCollector(o MetricOpts, s *Store) *StoreCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(o.Namespace, o.Subsystem, name), help, nil, o.ConstLabels)
+	}
+	return &StoreCollector{
+		store: s,
+		descs: map[string]*prometheus.Desc{
+			"free":    desc("db_free_pages", "number of free pages in the freelist"),
+			"pending": desc("db_pending_pages", "number of pending pages in the freelist"),
+			"alloc":   desc("db_free_alloc_bytes", "bytes allocated in free pages"),
+			"inuse":   desc("db_freelist_inuse_bytes", "bytes used by the freelist"),
+			"open":    desc("db_open_read_transactions", "number of currently open read transactions"),
+			"size":    desc("db_file_size_bytes", "size of the db file"),
+		},
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *StoreCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.descs {
+		ch <- d
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *StoreCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.store.Stats()
+	gauge := func(name string, v int) {
+		ch <- prometheus.MustNewConstMetric(c.descs[name], prometheus.GaugeValue, float64(v))
+	}
+	gauge("free", stats.FreePageN)
+	gauge("pending", stats.PendingPageN)
+	gauge("alloc", stats.FreeAlloc)
+	gauge("inuse", stats.FreelistInuse)
+	gauge("open", stats.OpenTxN)
+	if fi, err := os.Stat(c.store.Path()); err == nil {
+		gauge("size", int(fi.Size()))
+	}
+}
@@ -0,0 +1,247 @@
+package insight
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OtherName collects apps and types which exceed a limit
+const OtherName = "__other__"
+
+// Limit names
+const (
+	LimitApps   = "apps"
+	LimitTypes  = "types"
+	LimitSeries = "series"
+)
+
+// Limits caps the number of distinct apps, types per app and app and type pairs
+//
+// The only labels with client supplied values are app and type, so Series is what limits
+// the number of label values, counting each pair of them as one exported series.
+// Once a limit is reached calls of new names are counted as OtherName, or rejected if Reject is set.
+// OtherName itself is never limited. A limit of 0 disables it.
+type Limits struct {
+	Apps   int  `json:"apps"`
+	Types  int  `json:"types"`
+	Series int  `json:"series"`
+	Reject bool `json:"reject"`
+}
+
+// limitError is returned for calls rejected by a limit
+type limitError string
+
+func (e limitError) Error() string {
+	return "limit of " + string(e) + " reached"
+}
+
+// apply returns the names to count ctype and app as and the limit which has been hit, if any
+func (l Limits) apply(tx *bolt.Tx, ctype, app string) (string, string, string, error) {
+	if l == (Limits{}) {
+		return ctype, app, "", nil
+	}
+	c, err := counts(tx)
+	if err != nil {
+		return ctype, app, "", err
+	}
+	root := tx.Bucket(AppsBucket)
+	var b *bolt.Bucket
+	if root != nil {
+		b = root.Bucket([]byte(app))
+	}
+
+	var limit string
+	if b == nil && app != OtherName && l.Apps > 0 && countOf(c, appsCountKey) >= l.Apps {
+		limit = LimitApps
+		app = OtherName
+		b = root.Bucket([]byte(app))
+	}
+	if ctype != OtherName && (b == nil || b.Get([]byte(ctype)) == nil) {
+		// the first limit hit is reported if the type is folded as well
+		hit := limit
+		switch {
+		case l.Types > 0 && countOf(c.Bucket(typeCountsBucket), []byte(app)) >= l.Types:
+			hit = LimitTypes
+			ctype = OtherName
+		case l.Series > 0 && countOf(c, seriesCountKey) >= l.Series:
+			hit = LimitSeries
+			ctype = OtherName
+		}
+		if limit == "" {
+			limit = hit
+		}
+	}
+	if limit != "" && l.Reject {
+		return ctype, app, limit, limitError(limit)
+	}
+	return ctype, app, limit, nil
+}
+
+// Running counts of apps and series are kept in MetaBucket, with the number of types of every app in a nested bucket
+var (
+	countsBucket     = []byte("counts")
+	typeCountsBucket = []byte("types")
+	appsCountKey     = []byte("apps")
+	seriesCountKey   = []byte("series")
+)
+
+// counts returns the bucket of running counts, rebuilding it from the apps if it does not exist
+func counts(tx *bolt.Tx) (*bolt.Bucket, error) {
+	meta, err := tx.CreateBucketIfNotExists(MetaBucket)
+	if err != nil {
+		return nil, err
+	}
+	if c := meta.Bucket(countsBucket); c != nil {
+		return c, nil
+	}
+	c, err := meta.CreateBucket(countsBucket)
+	if err != nil {
+		return nil, err
+	}
+	types, err := c.CreateBucket(typeCountsBucket)
+	if err != nil {
+		return nil, err
+	}
+	var apps, series uint64
+	if root := tx.Bucket(AppsBucket); root != nil {
+		err := root.ForEach(func(app, _ []byte) error {
+			n := uint64(countKeys(root.Bucket(app)))
+			apps++
+			series += n
+			return types.Put(clone(app), EncodeCount(n))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := c.Put(appsCountKey, EncodeCount(apps)); err != nil {
+		return nil, err
+	}
+	return c, c.Put(seriesCountKey, EncodeCount(series))
+}
+
+// addSeries records a new type of app in the running counts c, which is a new app as well if newApp is set
+func addSeries(c *bolt.Bucket, app []byte, newApp bool) error {
+	if newApp {
+		if err := incr(c, appsCountKey, 1); err != nil {
+			return err
+		}
+	}
+	if err := incr(c, seriesCountKey, 1); err != nil {
+		return err
+	}
+	return incr(c.Bucket(typeCountsBucket), app, 1)
+}
+
+// ResetCounts drops the running counts, which are rebuilt when they are needed next
+//
+// It has to be called whenever apps or types are removed or written other than by counting calls.
+func ResetCounts(tx *bolt.Tx) error {
+	meta := tx.Bucket(MetaBucket)
+	if meta == nil || meta.Bucket(countsBucket) == nil {
+		return nil
+	}
+	return meta.DeleteBucket(countsBucket)
+}
+
+func countOf(b *bolt.Bucket, key []byte) int {
+	n, _ := DecodeCount(b.Get(key))
+	return int(n)
+}
+
+func countKeys(b *bolt.Bucket) (n int) {
+	if b == nil {
+		return 0
+	}
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		n++
+	}
+	return n
+}
+
+// limited records a call which hit limit
+func (i *Instruments) limited(limit string, rejected bool) {
+	if i == nil {
+		return
+	}
+	action := "other"
+	if rejected {
+		action = "rejected"
+	}
+	i.Limited.WithLabelValues(limit, action).Inc()
+}
+
+// newLimitedCounter creates the counter of calls which hit a limit
+func newLimitedCounter(o MetricOpts) *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   o.Namespace,
+		Subsystem:   o.Subsystem,
+		Name:        "limited_calls_total",
+		Help:        "calls which hit a cardinality limit per limit and action",
+		ConstLabels: o.ConstLabels,
+	}, []string{"limit", "action"})
+}
+
+// AppCardinality is the number of types of an app
+type AppCardinality struct {
+	App   string `json:"app"`
+	Types int    `json:"types"`
+}
+
+// CardinalityReport describes the number of stored names compared to the limits
+type CardinalityReport struct {
+	Apps   int              `json:"apps"`
+	Series int              `json:"series"`
+	Hidden int              `json:"hidden"`
+	Limits Limits           `json:"limits"`
+	Top    []AppCardinality `json:"top"`
+}
+
+// CardinalityHandler reports the number of apps and series and the apps with the most types
+//
+// The limit parameter sets the number of listed apps, 20 by default.
+func CardinalityHandler(s Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		top := 20
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, fmt.Sprintf("invalid limit: %q", v), http.StatusBadRequest)
+				return
+			}
+			top = n
+		}
+		report := CardinalityReport{Hidden: s.Stale.Hidden(), Limits: s.Rules.Limits(), Top: []AppCardinality{}}
+		s.Db.View(func(tx *bolt.Tx) error {
+			root := tx.Bucket(AppsBucket)
+			if root == nil {
+				return nil
+			}
+			return root.ForEach(func(app, _ []byte) error {
+				n := countKeys(root.Bucket(app))
+				report.Apps++
+				report.Series += n
+				report.Top = append(report.Top, AppCardinality{App: string(app), Types: n})
+				return nil
+			})
+		})
+		sort.Slice(report.Top, func(i, j int) bool {
+			if report.Top[i].Types != report.Top[j].Types {
+				return report.Top[i].Types > report.Top[j].Types
+			}
+			return report.Top[i].App < report.Top[j].App
+		})
+		if len(report.Top) > top {
+			report.Top = report.Top[:top]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
@@ -0,0 +1,77 @@
+package insight
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// storedCounts returns the running counts of apps, series and types of app
+func storedCounts(t *testing.T, db DB, app string) (apps, series, types int) {
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(MetaBucket).Bucket(countsBucket)
+		apps, series = countOf(c, appsCountKey), countOf(c, seriesCountKey)
+		types = countOf(c.Bucket(typeCountsBucket), []byte(app))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return apps, series, types
+}
+
+func TestLimits(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	s := testServer(db)
+	s.Rules = NewRules(Validation{}, Limits{Apps: 2, Types: 2, Series: 4})
+
+	calls := []struct {
+		app, ctype string
+	}{
+		{"a", "x"}, {"a", "x"}, {"a", "y"},
+		{"a", "z"}, // over the types of a
+		{"b", "x"},
+		{"b", "y"}, // over the series
+		{"c", "x"}, // over the apps
+	}
+	for _, c := range calls {
+		if err := s.Count(c.ctype, c.app); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var totals Totals
+	err := db.View(func(tx *bolt.Tx) (err error) {
+		totals, err = ReadTotals(tx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"a/x": 2, "a/y": 1, "a/" + OtherName: 1, "b/x": 1, "b/" + OtherName: 1, OtherName + "/" + OtherName: 1}
+	for key, n := range want {
+		parts := strings.SplitN(key, "/", 2)
+		if totals[parts[0]][parts[1]] != n {
+			t.Errorf("%s: got %d, want %d in %v", key, totals[parts[0]][parts[1]], n, totals)
+		}
+	}
+	if apps, series, types := storedCounts(t, db, "a"); apps != 3 || series != 6 || types != 3 {
+		t.Errorf("got %d apps, %d series and %d types of a, want 3, 6 and 3", apps, series, types)
+	}
+
+	// the counts are rebuilt after an admin op, with b deleted and __other__ being an app itself
+	w := httptest.NewRecorder()
+	AdminHandler(s, "delete-app")(w, httptest.NewRequest(http.MethodPost, "/admin/delete-app", strings.NewReader(`{"app": "b"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete-app: %d %s", w.Code, w.Body)
+	}
+	if err := s.Count("y", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if apps, series, types := storedCounts(t, db, "a"); apps != 2 || series != 4 || types != 3 {
+		t.Errorf("got %d apps, %d series and %d types of a after deleting b, want 2, 4 and 3", apps, series, types)
+	}
+}
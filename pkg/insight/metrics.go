@@ -0,0 +1,78 @@
+package insight
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricOpts names the exported call counter and its labels
+type MetricOpts struct {
+	Namespace string
+	Subsystem string
+	Name      string
+	Help      string
+	TypeLabel string
+	AppLabel  string
+	// ConstLabels are added to every series, e.g. to tell deployments apart
+	ConstLabels map[string]string
+}
+
+// DefaultMetricOpts returns the names insight has always been using
+func DefaultMetricOpts() MetricOpts {
+	return MetricOpts{
+		Namespace: "infinity",
+		Subsystem: "insight",
+		Name:      "calls_sum",
+		Help:      "total count of calls",
+		TypeLabel: "type",
+		AppLabel:  "app",
+	}
+}
+
+// NewCounterVec creates the call counter, the variable labels are ordered type, app
+func (o MetricOpts) NewCounterVec() *prometheus.CounterVec {
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   o.Namespace,
+		Subsystem:   o.Subsystem,
+		Name:        o.Name,
+		Help:        o.Help,
+		ConstLabels: o.ConstLabels,
+	}, []string{o.typeLabel(), o.appLabel()})
+}
+
+func (o MetricOpts) typeLabel() string {
+	if o.TypeLabel == "" {
+		return "type"
+	}
+	return o.TypeLabel
+}
+
+func (o MetricOpts) appLabel() string {
+	if o.AppLabel == "" {
+		return "app"
+	}
+	return o.AppLabel
+}
+
+// labels returns the label name and value pairs of a series
+func (o MetricOpts) labels(ctype, app string) []string {
+	return []string{o.typeLabel(), ctype, o.appLabel(), app}
+}
+
+// ParseLabels reads constant labels like "instance_group=prod,region=eu"
+func ParseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	if s == "" {
+		return labels, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, fmt.Errorf("invalid label: %q", pair)
+		}
+		labels[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+	return labels, nil
+}
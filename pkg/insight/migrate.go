@@ -0,0 +1,163 @@
+package insight
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/boltdb/bolt"
+	"go.uber.org/zap"
+)
+
+// Migration upgrades the db layout to Version
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(tx *bolt.Tx) error
+}
+
+// Migrations lists all layout changes in the order they have to be applied
+var Migrations = []Migration{
+	{1, "nest apps below a namespaced root and store counters as fixed width integers", migrateNamespacedBinary},
+	{2, "derive first and last seen times from the history", migrateSeen},
+}
+
+// CurrentSchema is the layout version written by this build
+func CurrentSchema() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+// Migrate applies all pending migrations to db, each in its own transaction
+func Migrate(log *zap.Logger, db DB) error {
+	var version int
+	err := db.View(func(tx *bolt.Tx) (err error) {
+		version, err = SchemaVersion(tx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if version > CurrentSchema() {
+		return fmt.Errorf("db schema version %d is newer than supported version %d", version, CurrentSchema())
+	}
+	for _, m := range Migrations {
+		if m.Version <= version {
+			continue
+		}
+		log.Info("migrating db", zap.Int("from", version), zap.Int("to", m.Version), zap.String("migration", m.Description))
+		err := db.Update(func(tx *bolt.Tx) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return setSchemaVersion(tx, m.Version)
+		})
+		if err != nil {
+			return fmt.Errorf("migration to version %d failed: %v", m.Version, err)
+		}
+		version = m.Version
+	}
+	return nil
+}
+
+var (
+	legacyHistory    = []byte("__history__")
+	legacyQuarantine = []byte("__quarantine__")
+)
+
+// migrateNamespacedBinary moves the top level app buckets below AppsBucket and converts decimal counters
+//
+// Counters which can not be parsed are moved into the quarantine instead of failing the migration.
+func migrateNamespacedBinary(tx *bolt.Tx) error {
+	var names [][]byte
+	err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		for _, root := range [][]byte{MetaBucket, AppsBucket, HistoryBucket, QuarantineBucket} {
+			if bytes.Equal(name, root) {
+				return fmt.Errorf("app bucket %q collides with an internal bucket, rename it first", name)
+			}
+		}
+		names = append(names, clone(name))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, root := range [][]byte{AppsBucket, HistoryBucket, QuarantineBucket} {
+		if _, err := tx.CreateBucket(root); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range names {
+		src := tx.Bucket(name)
+		switch {
+		case bytes.Equal(name, legacyQuarantine):
+			err = copyBucket(tx.Bucket(QuarantineBucket), src)
+		case bytes.Equal(name, legacyHistory):
+			err = src.ForEach(func(hour, _ []byte) error {
+				hb := src.Bucket(hour)
+				if hb == nil {
+					return nil
+				}
+				return hb.ForEach(func(app, _ []byte) error {
+					return convertCounters(tx, hb.Bucket(app), HistoryBucket, hour, app)
+				})
+			})
+		default:
+			err = convertCounters(tx, src, AppsBucket, name)
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertCounters writes the decimal counters of src as binary counters into the bucket at path
+func convertCounters(tx *bolt.Tx, src *bolt.Bucket, path ...[]byte) error {
+	if src == nil {
+		return nil
+	}
+	dst, err := bucketPath(tx, path...)
+	if err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			q, err := bucketPath(tx, append([][]byte{QuarantineBucket}, path...)...)
+			if err != nil {
+				return err
+			}
+			q, err = q.CreateBucketIfNotExists(clone(k))
+			if err != nil {
+				return err
+			}
+			return copyBucket(q, src.Bucket(k))
+		}
+		n, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			q, err := bucketPath(tx, append([][]byte{QuarantineBucket}, path...)...)
+			if err != nil {
+				return err
+			}
+			return q.Put(clone(k), clone(v))
+		}
+		return dst.Put(clone(k), EncodeCount(n))
+	})
+}
+
+// copyBucket recursively copies all keys and nested buckets of src into dst
+func copyBucket(dst, src *bolt.Bucket) error {
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(clone(k), clone(v))
+		}
+		sub, err := dst.CreateBucketIfNotExists(clone(k))
+		if err != nil {
+			return err
+		}
+		return copyBucket(sub, src.Bucket(k))
+	})
+}
@@ -0,0 +1,190 @@
+package insight
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/seibert-media/inf-insight/pkg/dbutil"
+	"go.uber.org/zap"
+)
+
+// openTestDb opens an empty db in a temporary directory, which is removed by the returned func
+func openTestDb(t *testing.T) (*bolt.DB, func()) {
+	dir, err := ioutil.TempDir("", "insight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "insight.db"), 0600, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// put stores v at k in the bucket at path, creating the buckets as needed
+func put(t *testing.T, db *bolt.DB, v string, path ...string) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		var names [][]byte
+		for _, p := range path[:len(path)-1] {
+			names = append(names, []byte(p))
+		}
+		b, err := dbutil.CreateBucketPath(tx, names)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(path[len(path)-1]), []byte(v))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// get returns the value at path, or nil if it does not exist
+func get(tx *bolt.Tx, path ...[]byte) []byte {
+	b := tx.Bucket(path[0])
+	for _, name := range path[1 : len(path)-1] {
+		if b == nil {
+			return nil
+		}
+		b = b.Bucket(name)
+	}
+	if b == nil {
+		return nil
+	}
+	return b.Get(path[len(path)-1])
+}
+
+// snapshot returns every key of db with its value
+func snapshot(t *testing.T, db *bolt.DB) map[string]string {
+	m := make(map[string]string)
+	err := db.View(func(tx *bolt.Tx) error {
+		return dbutil.Walk(tx, func(path [][]byte, k, v []byte) error {
+			m[string(bytes.Join(append(path, k), []byte("/")))] = string(v)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	put(t, db, "12", "deploytool", "started")
+	put(t, db, "x1", "deploytool", "broken")
+	put(t, db, "5", "other", "stopped")
+	put(t, db, "3", "deploytool", "nested", "deep")
+	put(t, db, "3", "__history__", "2018010215", "deploytool", "started")
+	put(t, db, "-1", "__history__", "2018010215", "deploytool", "failed")
+	put(t, db, "v", "__quarantine__", "old")
+
+	if err := Migrate(zap.NewNop(), db); err != nil {
+		t.Fatal(err)
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		for _, legacy := range []string{"deploytool", "__history__", "__quarantine__"} {
+			if tx.Bucket([]byte(legacy)) != nil {
+				t.Errorf("legacy bucket %s still exists", legacy)
+			}
+		}
+		version, err := SchemaVersion(tx)
+		if err != nil || version != CurrentSchema() {
+			t.Errorf("got schema version %d, %v, want %d", version, err, CurrentSchema())
+		}
+
+		counts := []struct {
+			path []string
+			want uint64
+		}{
+			{[]string{string(AppsBucket), "deploytool", "started"}, 12},
+			{[]string{string(AppsBucket), "other", "stopped"}, 5},
+			{[]string{string(HistoryBucket), "2018010215", "deploytool", "started"}, 3},
+		}
+		for _, c := range counts {
+			n, err := DecodeCount(get(tx, bytesPath(c.path)...))
+			if err != nil || n != c.want {
+				t.Errorf("%s: got %d, %v, want %d", strings.Join(c.path, "/"), n, err, c.want)
+			}
+		}
+
+		quarantined := []struct {
+			path []string
+			want string
+		}{
+			{[]string{string(QuarantineBucket), "old"}, "v"},
+			{[]string{string(QuarantineBucket), string(AppsBucket), "deploytool", "broken"}, "x1"},
+			{[]string{string(QuarantineBucket), string(AppsBucket), "deploytool", "nested", "deep"}, "3"},
+			{[]string{string(QuarantineBucket), string(HistoryBucket), "2018010215", "deploytool", "failed"}, "-1"},
+		}
+		for _, q := range quarantined {
+			if v := get(tx, bytesPath(q.path)...); string(v) != q.want {
+				t.Errorf("%s: got %q, want %q", strings.Join(q.path, "/"), v, q.want)
+			}
+		}
+		if v := get(tx, AppsBucket, []byte("deploytool"), []byte("broken")); v != nil {
+			t.Errorf("invalid counter migrated as %x", v)
+		}
+
+		st, err := ReadSeen(tx)
+		if err != nil {
+			return err
+		}
+		if first := st["deploytool"]["started"].First; !first.Equal(time.Date(2018, 1, 2, 15, 0, 0, 0, time.UTC)) {
+			t.Errorf("got first seen %v, want the start of the history hour", first)
+		}
+		// only totals without history, whose seen times are unknown
+		if s, ok := st["other"]["stopped"]; ok {
+			t.Errorf("got seen times %v for a type without history", s)
+		}
+		if since := seenSince(tx); time.Since(since) > time.Minute {
+			t.Errorf("got seen times recorded since %v, want the migration time", since)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := snapshot(t, db)
+	if err := Migrate(zap.NewNop(), db); err != nil {
+		t.Fatal(err)
+	}
+	if after := snapshot(t, db); !reflect.DeepEqual(before, after) {
+		t.Errorf("second migration changed the db\nbefore: %v\nafter:  %v", before, after)
+	}
+}
+
+func TestMigrateNewerSchema(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	err := db.Update(func(tx *bolt.Tx) error {
+		return setSchemaVersion(tx, CurrentSchema()+1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(zap.NewNop(), db); err == nil {
+		t.Error("migrated a db written by a newer version")
+	}
+}
+
+func bytesPath(path []string) [][]byte {
+	b := make([][]byte, len(path))
+	for i, p := range path {
+		b[i] = []byte(p)
+	}
+	return b
+}
@@ -0,0 +1,125 @@
+package insight
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+
+	"github.com/boltdb/bolt"
+)
+
+// replayBatch is the number of events written per transaction during replay
+const replayBatch = 10000
+
+// Replay rebuilds the totals, history, seen times and alias statistics in dst from the event log of src
+//
+// Names are resolved with the given aliases. The alias definitions, the audit log
+// and the event log itself are copied as well. The totals of dst only match those of src
+// if every call has been logged, no admin op has changed the counts and the aliases are the same,
+// so callers have to check them with DiffTotals before dst replaces src.
+// dst has to be migrated to the current schema and must not contain any apps.
+func Replay(src, dst DB, aliases *Aliases) (int, error) {
+	err := dst.Update(func(tx *bolt.Tx) error {
+		if root := tx.Bucket(AppsBucket); root != nil && root.Stats().KeyN > 0 {
+			return errors.New("replay target already contains apps")
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	err = src.View(func(stx *bolt.Tx) error {
+		err := dst.Update(func(dtx *bolt.Tx) error {
+			for _, name := range [][]byte{AliasBucket, AuditBucket} {
+				if b := stx.Bucket(name); b != nil {
+					copied, err := dtx.CreateBucketIfNotExists(name)
+					if err != nil {
+						return err
+					}
+					if err := copyBucket(copied, b); err != nil {
+						return err
+					}
+					if err := copied.SetSequence(b.Sequence()); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		events := stx.Bucket(EventsBucket)
+		if events == nil {
+			return nil
+		}
+		c := events.Cursor()
+		k, v := c.First()
+		for k != nil {
+			err := dst.Update(func(dtx *bolt.Tx) error {
+				log, err := dtx.CreateBucketIfNotExists(EventsBucket)
+				if err != nil {
+					return err
+				}
+				for i := 0; k != nil && i < replayBatch; i++ {
+					var e Event
+					if err := json.Unmarshal(v, &e); err != nil {
+						return err
+					}
+					app, ctype, hits := aliases.Resolve(e.App, e.Type)
+					if _, _, err := aggregate(dtx, e.Time, ctype, app, hits); err != nil {
+						return err
+					}
+					if err := log.Put(clone(k), clone(v)); err != nil {
+						return err
+					}
+					n++
+					k, v = c.Next()
+				}
+				return log.SetSequence(events.Sequence())
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+// TotalsDiff is a series counted differently in two dbs
+type TotalsDiff struct {
+	App  string
+	Type string
+	Want int
+	Got  int
+}
+
+// DiffTotals returns all series whose counts in want and got differ, sorted by app and type
+func DiffTotals(want, got Totals) []TotalsDiff {
+	var diffs []TotalsDiff
+	for app, types := range want {
+		for ctype, n := range types {
+			if got[app][ctype] != n {
+				diffs = append(diffs, TotalsDiff{App: app, Type: ctype, Want: n, Got: got[app][ctype]})
+			}
+		}
+	}
+	for app, types := range got {
+		for ctype, n := range types {
+			if _, ok := want[app][ctype]; !ok {
+				diffs = append(diffs, TotalsDiff{App: app, Type: ctype, Got: n})
+			}
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if diffs[i].App != diffs[j].App {
+			return diffs[i].App < diffs[j].App
+		}
+		return diffs[i].Type < diffs[j].Type
+	})
+	return diffs
+}
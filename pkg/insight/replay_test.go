@@ -0,0 +1,76 @@
+package insight
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/boltdb/bolt"
+	"go.uber.org/zap"
+)
+
+func TestReplay(t *testing.T) {
+	src, doneSrc := openTestDb(t)
+	defer doneSrc()
+	dst, doneDst := openTestDb(t)
+	defer doneDst()
+	for _, db := range []*bolt.DB{src, dst} {
+		if err := Migrate(zap.NewNop(), db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := testServer(src)
+	s.EventLog = true
+	for _, ctype := range []string{"started", "started", "stopped"} {
+		if err := s.Count(ctype, "deploytool"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// counted before the event log was enabled
+	s.EventLog = false
+	if err := s.Count("started", "other"); err != nil {
+		t.Fatal(err)
+	}
+
+	aliases, err := NewAliases(src, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := Replay(src, dst, aliases)
+	if err != nil || n != 3 {
+		t.Fatalf("replayed %d events, %v, want 3", n, err)
+	}
+	totals := func(db *bolt.DB) (totals Totals) {
+		err := db.View(func(tx *bolt.Tx) (err error) {
+			totals, err = ReadTotals(tx)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return totals
+	}
+	want := []TotalsDiff{{App: "other", Type: "started", Want: 1}}
+	if diffs := DiffTotals(totals(src), totals(dst)); !reflect.DeepEqual(diffs, want) {
+		t.Errorf("got diffs %v, want %v", diffs, want)
+	}
+	if _, err := Replay(src, dst, aliases); err == nil {
+		t.Error("replayed into a db which already contains apps")
+	}
+}
+
+func TestDiffTotals(t *testing.T) {
+	want := Totals{"a": {"x": 1, "y": 2}, "b": {"x": 3}}
+	got := Totals{"a": {"x": 1, "y": 3}, "c": {"z": 4}}
+	diffs := DiffTotals(want, got)
+	expected := []TotalsDiff{
+		{App: "a", Type: "y", Want: 2, Got: 3},
+		{App: "b", Type: "x", Want: 3},
+		{App: "c", Type: "z", Got: 4},
+	}
+	if !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("got %v, want %v", diffs, expected)
+	}
+	if diffs := DiffTotals(want, want); len(diffs) != 0 {
+		t.Errorf("got %v for equal totals", diffs)
+	}
+}
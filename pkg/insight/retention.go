@@ -0,0 +1,266 @@
+package insight
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.uber.org/zap"
+)
+
+// DayLayout is the time layout used for the keys of the daily history buckets
+const DayLayout = "20060102"
+
+// DailyBucket holds the history rolled up per day, with the same layout as HistoryBucket
+var DailyBucket = []byte("insight:daily")
+
+// Duration is a time.Duration read from strings like "720h", 0 keeps data forever
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// AppRetention overrides the history retention for a single app
+type AppRetention struct {
+	Hourly *Duration `json:"hourly"`
+	Daily  *Duration `json:"daily"`
+}
+
+// Retention defines how long data is kept per granularity
+//
+// Hourly history older than Hourly is rolled up into daily history,
+// daily history older than Daily and events older than Events are deleted.
+// A zero duration keeps data forever.
+type Retention struct {
+	Events time.Duration
+	Hourly time.Duration
+	Daily  time.Duration
+	Apps   map[string]AppRetention
+}
+
+// LoadRetentionOverrides reads per app overrides like {"deploytool": {"hourly": "8760h", "daily": "0s"}}
+func LoadRetentionOverrides(path string) (map[string]AppRetention, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var apps map[string]AppRetention
+	return apps, json.Unmarshal(b, &apps)
+}
+
+func (r Retention) hourly(app string) time.Duration {
+	if o, ok := r.Apps[app]; ok && o.Hourly != nil {
+		return time.Duration(*o.Hourly)
+	}
+	return r.Hourly
+}
+
+func (r Retention) daily(app string) time.Duration {
+	if o, ok := r.Apps[app]; ok && o.Daily != nil {
+		return time.Duration(*o.Daily)
+	}
+	return r.Daily
+}
+
+// expired reports whether the period [start, start+length) lies completely before now-keep
+func expired(start time.Time, length, keep time.Duration, now time.Time) bool {
+	return keep > 0 && !start.Add(length).After(now.Add(-keep))
+}
+
+// RetentionStats counts what a retention run changed
+type RetentionStats struct {
+	RolledUp      int
+	DeletedDays   int
+	DeletedEvents int
+}
+
+// ApplyHistory rolls up expired hourly history into daily history and deletes expired daily history
+func (r Retention) ApplyHistory(tx *bolt.Tx, now time.Time) (RetentionStats, error) {
+	var stats RetentionStats
+	hours := tx.Bucket(HistoryBucket)
+	for _, hour := range bucketNames(hours) {
+		t, err := time.Parse(HourLayout, string(hour))
+		hb := hours.Bucket(hour)
+		if err != nil || hb == nil {
+			continue
+		}
+		day := t.Truncate(24 * time.Hour)
+		for _, app := range bucketNames(hb) {
+			if !expired(day, 24*time.Hour, r.hourly(string(app)), now) {
+				continue
+			}
+			dst, err := bucketPath(tx, DailyBucket, []byte(day.Format(DayLayout)), app)
+			if err != nil {
+				return stats, err
+			}
+			err = hb.Bucket(app).ForEach(func(ctype, v []byte) error {
+				n, err := DecodeCount(v)
+				if err != nil {
+					return fmt.Errorf("%s/%s/%s: %v", hour, app, ctype, err)
+				}
+				return incr(dst, clone(ctype), n)
+			})
+			if err != nil {
+				return stats, err
+			}
+			if err := hb.DeleteBucket(app); err != nil {
+				return stats, err
+			}
+			stats.RolledUp++
+		}
+		if err := deleteIfEmpty(hours, hour); err != nil {
+			return stats, err
+		}
+	}
+
+	days := tx.Bucket(DailyBucket)
+	for _, day := range bucketNames(days) {
+		t, err := time.Parse(DayLayout, string(day))
+		db := days.Bucket(day)
+		if err != nil || db == nil {
+			continue
+		}
+		for _, app := range bucketNames(db) {
+			if !expired(t, 24*time.Hour, r.daily(string(app)), now) {
+				continue
+			}
+			if err := db.DeleteBucket(app); err != nil {
+				return stats, err
+			}
+			stats.DeletedDays++
+		}
+		if err := deleteIfEmpty(days, day); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// bucketNames returns a copy of all keys in b, so b can be modified while walking them
+func bucketNames(b *bolt.Bucket) [][]byte {
+	if b == nil {
+		return nil
+	}
+	var names [][]byte
+	b.ForEach(func(k, _ []byte) error {
+		names = append(names, clone(k))
+		return nil
+	})
+	return names
+}
+
+// deleteIfEmpty removes the nested bucket name from parent if it has no keys left
+func deleteIfEmpty(parent *bolt.Bucket, name []byte) error {
+	if k, _ := parent.Bucket(name).Cursor().First(); k != nil {
+		return nil
+	}
+	return parent.DeleteBucket(name)
+}
+
+// ApplyEvents deletes up to limit expired events and returns how many have been deleted
+func (r Retention) ApplyEvents(tx *bolt.Tx, now time.Time, limit int) (int, error) {
+	b := tx.Bucket(EventsBucket)
+	if b == nil || r.Events <= 0 {
+		return 0, nil
+	}
+	max := EventKey(now.Add(-r.Events), 0)
+	var n int
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && n < limit && bytes.Compare(k, max) < 0; k, _ = c.First() {
+		if err := c.Delete(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// RetentionJob applies a retention policy on a schedule and compacts the db afterwards
+type RetentionJob struct {
+	Log      *zap.Logger
+	Store    *Store
+	Policy   Retention
+	Interval time.Duration
+	// Compact rewrites the db file after data has been removed, if at least CompactRatio of it is free
+	Compact      bool
+	CompactRatio float64
+}
+
+// Run blocks until done is closed and applies the policy every Interval
+func (j RetentionJob) Run(done <-chan struct{}) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if err := j.Apply(now); err != nil {
+				j.Log.Error("retention error", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Apply runs the policy once
+func (j RetentionJob) Apply(now time.Time) error {
+	var stats RetentionStats
+	err := j.Store.Update(func(tx *bolt.Tx) (err error) {
+		stats, err = j.Policy.ApplyHistory(tx, now)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	// delete events in batches to keep write transactions short
+	for {
+		var n int
+		err := j.Store.Update(func(tx *bolt.Tx) (err error) {
+			n, err = j.Policy.ApplyEvents(tx, now, replayBatch)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		stats.DeletedEvents += n
+		if n < replayBatch {
+			break
+		}
+	}
+	j.Log.Info("applied retention",
+		zap.Int("rolledUp", stats.RolledUp),
+		zap.Int("deletedDays", stats.DeletedDays),
+		zap.Int("deletedEvents", stats.DeletedEvents),
+	)
+	if !j.Compact || stats == (RetentionStats{}) {
+		return nil
+	}
+	free, err := j.Store.FreeRatio()
+	if err != nil {
+		return err
+	}
+	if free < j.CompactRatio {
+		j.Log.Debug("skipped compaction", zap.Float64("free", free))
+		return nil
+	}
+	before, after, err := j.Store.Compact()
+	if err != nil {
+		return err
+	}
+	j.Log.Info("compacted db", zap.Int64("before", before), zap.Int64("after", after))
+	return nil
+}
@@ -0,0 +1,155 @@
+package insight
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.uber.org/zap"
+)
+
+func openTestStore(t *testing.T) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "insight")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenStore(filepath.Join(dir, "insight.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRetentionApply(t *testing.T) {
+	store, done := openTestStore(t)
+	defer done()
+	now := time.Date(2018, 3, 10, 12, 0, 0, 0, time.UTC)
+	err := store.Update(func(tx *bolt.Tx) error {
+		for _, c := range []struct {
+			root   []byte
+			period string
+			app    string
+			n      uint64
+		}{
+			{HistoryBucket, "2018030110", "a", 2},
+			{HistoryBucket, "2018030111", "a", 3},
+			{HistoryBucket, "2018031011", "a", 1},
+			{HistoryBucket, "2018030110", "keep", 4},
+			{DailyBucket, "20180215", "a", 7},
+		} {
+			b, err := bucketPath(tx, c.root, []byte(c.period), []byte(c.app))
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte("x"), EncodeCount(c.n)); err != nil {
+				return err
+			}
+		}
+		for _, age := range []time.Duration{48 * time.Hour, time.Hour} {
+			if err := appendEvent(tx, Event{Time: now.Add(-age), App: "a", Type: "x"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forever := Duration(0)
+	job := RetentionJob{
+		Log:   zap.NewNop(),
+		Store: store,
+		Policy: Retention{
+			Events: 24 * time.Hour,
+			Hourly: 48 * time.Hour,
+			Daily:  10 * 24 * time.Hour,
+			Apps:   map[string]AppRetention{"keep": {Hourly: &forever}},
+		},
+		Compact: true,
+	}
+	if err := job.Apply(now); err != nil {
+		t.Fatal(err)
+	}
+
+	history := make(map[string]uint64)
+	var events int
+	err = store.View(func(tx *bolt.Tx) error {
+		for _, root := range [][]byte{HistoryBucket, DailyBucket} {
+			rb := tx.Bucket(root)
+			for _, period := range bucketNames(rb) {
+				for _, app := range bucketNames(rb.Bucket(period)) {
+					n, err := DecodeCount(rb.Bucket(period).Bucket(app).Get([]byte("x")))
+					if err != nil {
+						return err
+					}
+					history[string(root)+"/"+string(period)+"/"+string(app)] = n
+				}
+			}
+		}
+		events = tx.Bucket(EventsBucket).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint64{
+		"insight:history/2018030110/keep": 4,
+		"insight:history/2018031011/a":    1,
+		"insight:daily/20180301/a":        5,
+	}
+	if !reflect.DeepEqual(history, want) {
+		t.Errorf("got history %v, want %v", history, want)
+	}
+	if events != 1 {
+		t.Errorf("got %d events, want 1", events)
+	}
+}
+
+func TestStoreCompactWhileCounting(t *testing.T) {
+	store, done := openTestStore(t)
+	defer done()
+	s := testServer(store)
+	const calls = 500
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < calls; i++ {
+			if err := s.Count("started", "deploytool"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if _, _, err := store.Compact(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	var totals Totals
+	err := store.View(func(tx *bolt.Tx) (err error) {
+		totals, err = ReadTotals(tx)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := totals["deploytool"]["started"]; n != calls {
+		t.Errorf("got %d calls after compaction, want %d", n, calls)
+	}
+	if _, err := os.Stat(store.Path() + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("compact file left behind: %v", err)
+	}
+}
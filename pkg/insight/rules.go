@@ -0,0 +1,69 @@
+package insight
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+)
+
+// Validation restricts the app and type names of incoming calls
+//
+// A MaxLength of 0 and a nil Pattern accept every name.
+type Validation struct {
+	MaxLength int
+	Pattern   *regexp.Regexp
+}
+
+// validate checks the names a call is counted as
+func (v Validation) validate(ctype, app string) error {
+	for _, name := range []string{app, ctype} {
+		if v.MaxLength > 0 && len(name) > v.MaxLength {
+			return validationError(fmt.Sprintf("name longer than %d characters: %q", v.MaxLength, name))
+		}
+		if v.Pattern != nil && !v.Pattern.MatchString(name) {
+			return validationError(fmt.Sprintf("name does not match %s: %q", v.Pattern, name))
+		}
+	}
+	return nil
+}
+
+// Rules holds the validation and limits applied to every call
+//
+// They can be replaced while the server is running, e.g. when the config is reloaded.
+type Rules struct {
+	mu         sync.RWMutex
+	validation Validation
+	limits     Limits
+}
+
+// NewRules creates rules with the given validation and limits
+func NewRules(v Validation, l Limits) *Rules {
+	return &Rules{validation: v, limits: l}
+}
+
+// Set replaces the validation and limits
+func (r *Rules) Set(v Validation, l Limits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.validation, r.limits = v, l
+}
+
+// Validation returns the current validation, which accepts everything if r is nil
+func (r *Rules) Validation() Validation {
+	if r == nil {
+		return Validation{}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.validation
+}
+
+// Limits returns the current limits, which are disabled if r is nil
+func (r *Rules) Limits() Limits {
+	if r == nil {
+		return Limits{}
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.limits
+}
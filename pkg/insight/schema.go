@@ -0,0 +1,85 @@
+package insight
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// Top level buckets of the current schema
+//
+// Apps live below AppsBucket, so new internal buckets can never collide with app names.
+var (
+	MetaBucket       = []byte("insight:meta")
+	AppsBucket       = []byte("insight:apps")
+	HistoryBucket    = []byte("insight:history")
+	QuarantineBucket = []byte("insight:quarantine")
+
+	versionKey = []byte("schema_version")
+)
+
+// counterSize is the width of an encoded counter
+const counterSize = 8
+
+// EncodeCount returns the fixed width big endian encoding of n
+func EncodeCount(n uint64) []byte {
+	b := make([]byte, counterSize)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+// DecodeCount parses a counter written by EncodeCount
+func DecodeCount(b []byte) (uint64, error) {
+	if len(b) != counterSize {
+		return 0, fmt.Errorf("invalid counter of %d bytes", len(b))
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// incr adds delta to the counter stored at key
+func incr(b *bolt.Bucket, key []byte, delta uint64) error {
+	var n uint64
+	if v := b.Get(key); v != nil {
+		var err error
+		n, err = DecodeCount(v)
+		if err != nil {
+			return err
+		}
+	}
+	return b.Put(key, EncodeCount(n+delta))
+}
+
+// bucketPath creates all nested buckets along path and returns the innermost one
+func bucketPath(tx *bolt.Tx, path ...[]byte) (b *bolt.Bucket, err error) {
+	b, err = tx.CreateBucketIfNotExists(path[0])
+	for _, name := range path[1:] {
+		if err != nil {
+			return nil, err
+		}
+		b, err = b.CreateBucketIfNotExists(name)
+	}
+	return b, err
+}
+
+// SchemaVersion returns the layout version of the db, 0 for files written before versioning
+func SchemaVersion(tx *bolt.Tx) (int, error) {
+	meta := tx.Bucket(MetaBucket)
+	if meta == nil {
+		return 0, nil
+	}
+	v := meta.Get(versionKey)
+	if v == nil {
+		return 0, nil
+	}
+	n, err := DecodeCount(v)
+	return int(n), err
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	meta, err := tx.CreateBucketIfNotExists(MetaBucket)
+	if err != nil {
+		return err
+	}
+	return meta.Put(versionKey, EncodeCount(uint64(version)))
+}
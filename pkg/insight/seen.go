@@ -0,0 +1,345 @@
+package insight
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SeenBucket holds when each type of each app has been counted first and last, with the layout of AppsBucket
+var SeenBucket = []byte("insight:seen")
+
+// Seen is the time of the first and the last call, with second precision
+type Seen struct {
+	First time.Time `json:"firstSeen"`
+	Last  time.Time `json:"lastSeen"`
+}
+
+// merge returns the earliest first and latest last time of s and o
+func (s Seen) merge(o Seen) Seen {
+	if s.First.IsZero() || o.First.Before(s.First) {
+		s.First = o.First
+	}
+	if o.Last.After(s.Last) {
+		s.Last = o.Last
+	}
+	return s
+}
+
+// seen times are stored as unix time of the first call followed by the unix time of the last call
+func encodeSeen(s Seen) []byte {
+	b := make([]byte, 2*counterSize)
+	binary.BigEndian.PutUint64(b, uint64(s.First.Unix()))
+	binary.BigEndian.PutUint64(b[counterSize:], uint64(s.Last.Unix()))
+	return b
+}
+
+func decodeSeen(b []byte) (Seen, error) {
+	if len(b) != 2*counterSize {
+		return Seen{}, fmt.Errorf("invalid seen times of %d bytes", len(b))
+	}
+	return Seen{
+		First: time.Unix(int64(binary.BigEndian.Uint64(b)), 0).UTC(),
+		Last:  time.Unix(int64(binary.BigEndian.Uint64(b[counterSize:])), 0).UTC(),
+	}, nil
+}
+
+// mergeSeen merges s into the seen times stored at key
+func mergeSeen(b *bolt.Bucket, key []byte, s Seen) error {
+	if v := b.Get(key); v != nil {
+		stored, err := decodeSeen(v)
+		if err != nil {
+			return err
+		}
+		s = stored.merge(s)
+	}
+	return b.Put(key, encodeSeen(s))
+}
+
+// recordSeen updates the seen times of ctype and app with a call at t
+//
+// It reports whether app and ctype have not been seen before.
+func recordSeen(tx *bolt.Tx, t time.Time, ctype, app string) (newApp, newType bool, err error) {
+	root, err := tx.CreateBucketIfNotExists(SeenBucket)
+	if err != nil {
+		return false, false, err
+	}
+	newApp = root.Bucket([]byte(app)) == nil
+	b, err := root.CreateBucketIfNotExists([]byte(app))
+	if err != nil {
+		return false, false, err
+	}
+	if b.Get([]byte(ctype)) == nil {
+		newType = true
+		root.ForEach(func(other, _ []byte) error {
+			if ob := root.Bucket(other); ob != nil && ob.Get([]byte(ctype)) != nil {
+				newType = false
+			}
+			return nil
+		})
+	}
+	return newApp, newType, mergeSeen(b, []byte(ctype), Seen{First: t, Last: t})
+}
+
+// SeenTimes holds the seen times per app and type
+type SeenTimes map[string]map[string]Seen
+
+// Apps returns the seen times per app over all of its types
+func (st SeenTimes) Apps() map[string]Seen {
+	apps := make(map[string]Seen)
+	for app, types := range st {
+		for _, s := range types {
+			apps[app] = apps[app].merge(s)
+		}
+	}
+	return apps
+}
+
+// Types returns the seen times per type over all apps
+func (st SeenTimes) Types() map[string]Seen {
+	types := make(map[string]Seen)
+	for _, ts := range st {
+		for ctype, s := range ts {
+			types[ctype] = types[ctype].merge(s)
+		}
+	}
+	return types
+}
+
+// ReadSeen returns the seen times of all apps and types
+func ReadSeen(tx *bolt.Tx) (SeenTimes, error) {
+	st := make(SeenTimes)
+	root := tx.Bucket(SeenBucket)
+	if root == nil {
+		return st, nil
+	}
+	err := root.ForEach(func(app, _ []byte) error {
+		b := root.Bucket(app)
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(ctype, v []byte) error {
+			s, err := decodeSeen(v)
+			if err != nil {
+				return fmt.Errorf("%s/%s: %v", app, ctype, err)
+			}
+			if st[string(app)] == nil {
+				st[string(app)] = make(map[string]Seen)
+			}
+			st[string(app)][string(ctype)] = s
+			return nil
+		})
+	})
+	return st, err
+}
+
+// SeenGauges exports the seen times per app and per type
+type SeenGauges struct {
+	AppFirst  *prometheus.GaugeVec
+	AppLast   *prometheus.GaugeVec
+	TypeFirst *prometheus.GaugeVec
+	TypeLast  *prometheus.GaugeVec
+}
+
+// NewSeenGauges creates the seen gauges named after o
+func NewSeenGauges(o MetricOpts) *SeenGauges {
+	gauge := func(name, help, label string) *prometheus.GaugeVec {
+		return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   o.Subsystem,
+			Name:        name,
+			Help:        help,
+			ConstLabels: o.ConstLabels,
+		}, []string{label})
+	}
+	return &SeenGauges{
+		AppFirst:  gauge("app_first_seen_timestamp_seconds", "unix time of the first call of an app", o.appLabel()),
+		AppLast:   gauge("app_last_seen_timestamp_seconds", "unix time of the last call of an app", o.appLabel()),
+		TypeFirst: gauge("type_first_seen_timestamp_seconds", "unix time of the first call of a type", o.typeLabel()),
+		TypeLast:  gauge("type_last_seen_timestamp_seconds", "unix time of the last call of a type", o.typeLabel()),
+	}
+}
+
+// Collectors returns all gauges for registration
+func (g *SeenGauges) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{g.AppFirst, g.AppLast, g.TypeFirst, g.TypeLast}
+}
+
+// load replaces all exported seen times with st
+func (g *SeenGauges) load(st SeenTimes) {
+	if g == nil {
+		return
+	}
+	for _, v := range []*prometheus.GaugeVec{g.AppFirst, g.AppLast, g.TypeFirst, g.TypeLast} {
+		v.Reset()
+	}
+	for app, s := range st.Apps() {
+		g.AppFirst.WithLabelValues(app).Set(float64(s.First.Unix()))
+		g.AppLast.WithLabelValues(app).Set(float64(s.Last.Unix()))
+	}
+	for ctype, s := range st.Types() {
+		g.TypeFirst.WithLabelValues(ctype).Set(float64(s.First.Unix()))
+		g.TypeLast.WithLabelValues(ctype).Set(float64(s.Last.Unix()))
+	}
+}
+
+// seen exports a call of ctype and app at t
+func (g *SeenGauges) seen(t time.Time, ctype, app string, newApp, newType bool) {
+	if g == nil {
+		return
+	}
+	if newApp {
+		g.AppFirst.WithLabelValues(app).Set(float64(t.Unix()))
+	}
+	if newType {
+		g.TypeFirst.WithLabelValues(ctype).Set(float64(t.Unix()))
+	}
+	g.AppLast.WithLabelValues(app).Set(float64(t.Unix()))
+	g.TypeLast.WithLabelValues(ctype).Set(float64(t.Unix()))
+}
+
+// readSeen returns the seen times stored in tx if they are exported or needed to hide stale series
+func (s Server) readSeen(tx *bolt.Tx) (SeenTimes, error) {
+	if s.Seen == nil && s.Stale == nil {
+		return nil, nil
+	}
+	return ReadSeen(tx)
+}
+
+// reloadSeen exports the seen times st
+func (s Server) reloadSeen(st SeenTimes) {
+	if s.Seen == nil {
+		return
+	}
+	s.Seen.load(st)
+}
+
+// SeenResponse lists the seen times per app and per type
+type SeenResponse struct {
+	Apps  map[string]Seen `json:"apps"`
+	Types map[string]Seen `json:"types"`
+}
+
+// SeenHandler returns when apps and types have been called first and last
+//
+// With the app parameter only that app and its types are listed.
+func SeenHandler(s Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var resp SeenResponse
+		err := s.Db.View(func(tx *bolt.Tx) error {
+			st, err := ReadSeen(tx)
+			if err != nil {
+				return err
+			}
+			if app := r.URL.Query().Get("app"); app != "" {
+				if st[app] == nil {
+					return errNotFound
+				}
+				st = SeenTimes{app: st[app]}
+			}
+			resp = SeenResponse{Apps: st.Apps(), Types: st.Types()}
+			return nil
+		})
+		if err == errNotFound {
+			http.Error(w, "unknown app", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+var errNotFound = errors.New("not found")
+
+// migrateSeen derives the seen times of existing data from the hourly and daily history
+//
+// The first call is assumed at the start and the last call at the end of a period, but not after now.
+// Types with totals but without any history keep unknown seen times, as there is no record of their calls.
+func migrateSeen(tx *bolt.Tx) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	root, err := tx.CreateBucketIfNotExists(SeenBucket)
+	if err != nil {
+		return err
+	}
+	periods := []struct {
+		bucket []byte
+		layout string
+		length time.Duration
+	}{
+		{HistoryBucket, HourLayout, time.Hour},
+		{DailyBucket, DayLayout, 24 * time.Hour},
+	}
+	for _, p := range periods {
+		history := tx.Bucket(p.bucket)
+		if history == nil {
+			continue
+		}
+		err := history.ForEach(func(period, _ []byte) error {
+			start, err := time.Parse(p.layout, string(period))
+			pb := history.Bucket(period)
+			if err != nil || pb == nil {
+				return nil
+			}
+			s := Seen{First: start, Last: start.Add(p.length - time.Second)}
+			if s.Last.After(now) {
+				s.Last = now
+			}
+			return pb.ForEach(func(app, _ []byte) error {
+				ab := pb.Bucket(app)
+				if ab == nil {
+					return nil
+				}
+				dst, err := root.CreateBucketIfNotExists(clone(app))
+				if err != nil {
+					return err
+				}
+				return ab.ForEach(func(ctype, _ []byte) error {
+					return mergeSeen(dst, clone(ctype), s)
+				})
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return recordSeenSince(tx)
+}
+
+var seenSinceKey = []byte("seen_since")
+
+// recordSeenSince stores when seen times started to be recorded, unless it is known already
+//
+// Types with totals but without any history keep unknown seen times. StaleSeries counts their staleness from this time.
+func recordSeenSince(tx *bolt.Tx) error {
+	meta, err := tx.CreateBucketIfNotExists(MetaBucket)
+	if err != nil {
+		return err
+	}
+	if meta.Get(seenSinceKey) != nil {
+		return nil
+	}
+	return meta.Put(seenSinceKey, EncodeCount(uint64(time.Now().Unix())))
+}
+
+// seenSince returns when seen times started to be recorded, or the zero time if it is unknown
+func seenSince(tx *bolt.Tx) time.Time {
+	meta := tx.Bucket(MetaBucket)
+	if meta == nil {
+		return time.Time{}
+	}
+	n, err := DecodeCount(meta.Get(seenSinceKey))
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(int64(n), 0).UTC()
+}
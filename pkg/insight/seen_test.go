@@ -0,0 +1,165 @@
+package insight
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRecordSeen(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	start := time.Date(2018, 1, 2, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		at              time.Time
+		ctype, app      string
+		newApp, newType bool
+	}{
+		{start, "started", "deploytool", true, true},
+		{start.Add(time.Hour), "stopped", "deploytool", false, true},
+		{start.Add(2 * time.Hour), "started", "other", true, false},
+		{start.Add(-time.Hour), "started", "deploytool", false, false},
+	}
+	for _, test := range tests {
+		err := db.Update(func(tx *bolt.Tx) error {
+			newApp, newType, err := recordSeen(tx, test.at, test.ctype, test.app)
+			if newApp != test.newApp || newType != test.newType {
+				t.Errorf("%s/%s: got new app %v and type %v, want %v and %v", test.ctype, test.app, newApp, newType, test.newApp, test.newType)
+			}
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := db.View(func(tx *bolt.Tx) error {
+		st, err := ReadSeen(tx)
+		if err != nil {
+			return err
+		}
+		if s := st["deploytool"]["started"]; !s.First.Equal(start.Add(-time.Hour)) || !s.Last.Equal(start) {
+			t.Errorf("got %v for an earlier call, want the first time moved back only", s)
+		}
+		if s := st.Apps()["deploytool"]; !s.First.Equal(start.Add(-time.Hour)) || !s.Last.Equal(start.Add(time.Hour)) {
+			t.Errorf("got %v over all types of deploytool", s)
+		}
+		if s := st.Types()["started"]; !s.First.Equal(start.Add(-time.Hour)) || !s.Last.Equal(start.Add(2*time.Hour)) {
+			t.Errorf("got %v over all apps of started", s)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gaugeValue(t *testing.T, vec *prometheus.GaugeVec, label string) float64 {
+	var d dto.Metric
+	if err := vec.WithLabelValues(label).Write(&d); err != nil {
+		t.Fatal(err)
+	}
+	return d.Gauge.GetValue()
+}
+
+func TestSeenGauges(t *testing.T) {
+	g := NewSeenGauges(DefaultMetricOpts())
+	start := time.Date(2018, 1, 2, 15, 0, 0, 0, time.UTC)
+	g.load(SeenTimes{
+		"deploytool": {"started": {First: start, Last: start.Add(time.Hour)}},
+		"other":      {"started": {First: start.Add(-time.Hour), Last: start}},
+	})
+	g.seen(start.Add(2*time.Hour), "stopped", "other", false, true)
+
+	tests := []struct {
+		name  string
+		vec   *prometheus.GaugeVec
+		label string
+		want  time.Time
+	}{
+		{"app first", g.AppFirst, "deploytool", start},
+		{"app last", g.AppLast, "deploytool", start.Add(time.Hour)},
+		{"app last of a new type", g.AppLast, "other", start.Add(2 * time.Hour)},
+		{"type first over all apps", g.TypeFirst, "started", start.Add(-time.Hour)},
+		{"type last over all apps", g.TypeLast, "started", start.Add(time.Hour)},
+		{"new type first", g.TypeFirst, "stopped", start.Add(2 * time.Hour)},
+	}
+	for _, test := range tests {
+		if v := gaugeValue(t, test.vec, test.label); v != float64(test.want.Unix()) {
+			t.Errorf("%s: got %v, want %v", test.name, v, test.want.Unix())
+		}
+	}
+}
+
+func TestSeenHandler(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	s := testServer(db)
+	if err := s.Count("started", "deploytool"); err != nil {
+		t.Fatal(err)
+	}
+	// counted before seen times were recorded
+	put(t, db, string(EncodeCount(3)), string(AppsBucket), "legacy", "started")
+
+	tests := []struct {
+		query  string
+		status int
+		apps   int
+	}{
+		{"", http.StatusOK, 1},
+		{"?app=deploytool", http.StatusOK, 1},
+		{"?app=legacy", http.StatusNotFound, 0},
+		{"?app=unknown", http.StatusNotFound, 0},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		SeenHandler(s)(w, httptest.NewRequest(http.MethodGet, "/seen"+test.query, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d", test.query, w.Code, test.status)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var resp SeenResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Apps) != test.apps || time.Since(resp.Apps["deploytool"].Last) > time.Minute {
+			t.Errorf("%s: got %v", test.query, resp)
+		}
+	}
+}
+
+func TestStaleUnknownSeen(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	now := time.Now()
+	put(t, db, string(EncodeCount(3)), string(AppsBucket), "legacy", "started")
+	put(t, db, string(EncodeCount(uint64(now.Add(-30*time.Minute).Unix()))), string(MetaBucket), string(seenSinceKey))
+	s := testServer(db)
+	s.Stale = NewStaleSeries(time.Hour)
+	if _, err := s.LoadMetrics(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Count("started", "deploytool"); err != nil {
+		t.Fatal(err)
+	}
+	if m := exported(t, s); m["started/legacy"] != 3 {
+		t.Errorf("got series %v, want legacy exported within the window", m)
+	}
+
+	n, err := s.hideStale(now.Add(45 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := exported(t, s); n != 1 || len(m) != 1 || m["started/deploytool"] != 1 {
+		t.Errorf("hid %d series and got %v, want only legacy hidden", n, m)
+	}
+}
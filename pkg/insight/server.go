@@ -1,110 +1,313 @@
 package insight
 
 import (
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"sync"
+	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/go-kit/kit/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/seibert-media/inf-insight/pkg/trace"
 	"go.uber.org/zap"
 )
 
 // Server stores counters
+//
+// Counter wraps CounterVec, which is used directly to remove series.
+// Metric holds the label names both have been created with.
+// Aliases is optional and rewrites incoming names before counting.
+// With EventLog set, every call is also appended to the raw event log.
+// Instruments is optional and records failures and durations.
+// Seen is optional and exports when apps and types have been called first and last.
+// Stale is optional and hides series which have not been counted recently.
+// Rules is optional and validates and limits the names calls are counted as.
+// Clients is optional and restricts the apps a client certificate may report for.
+// Tracer is optional and records spans of every call, continuing the trace of its traceparent header.
+// SeriesMu is required and is held from the start of a transaction until the series it changes are exported,
+// so the exported series follow the order of commits.
 type Server struct {
-	Log     *zap.Logger
-	Counter metrics.Counter
-	Db      *bolt.DB
+	Log         *zap.Logger
+	Counter     metrics.Counter
+	CounterVec  *prometheus.CounterVec
+	Metric      MetricOpts
+	Db          DB
+	Aliases     *Aliases
+	EventLog    bool
+	Instruments *Instruments
+	Seen        *SeenGauges
+	Stale       *StaleSeries
+	Rules       *Rules
+	Clients     ClientApps
+	Tracer      *trace.Tracer
+	SeriesMu    *sync.Mutex
 }
 
 // Handler for monitoring actions
 func Handler(s Server) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.Log.Debug("started handling")
+		r, span := s.Tracer.StartRequest(r, "insight.add")
+		defer span.Finish()
+		log := requestLog(s.Log, r)
+		if span != nil {
+			log = log.With(zap.String("trace_id", hex.EncodeToString(span.Context.TraceID[:])))
+		}
+		log.Debug("started handling")
+		_, decodeSpan := s.Tracer.Start(r.Context(), "insight.decode")
 		req, err := decodeHTTPRequest(r)
+		decodeSpan.SetError(err)
+		decodeSpan.Finish()
+		if _, ok := err.(validationError); ok {
+			span.SetError(err)
+			s.Instruments.fail(FailureValidation)
+			log.Warn("rejected call", zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		if err != nil {
-			s.Log.Error("failed handling", zap.Error(err))
+			span.SetError(err)
+			s.Instruments.fail(FailureDecode)
+			log.Error("failed handling", zap.Error(err))
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			panic(err)
 		}
-		err = s.Count(req.Type, req.App)
+		app, ctype, hits := s.Aliases.Resolve(req.App, req.Type)
+		span.SetAttribute("insight.app", app)
+		span.SetAttribute("insight.type", ctype)
+		if len(hits) > 0 {
+			log.Debug("resolved aliases", zap.String("type", ctype), zap.String("app", app))
+		}
+		if s.Clients != nil && !s.Clients.allows(r, app) {
+			s.Instruments.fail(FailureForbidden)
+			span.SetError(errForbidden)
+			log.Warn("client may not report for app", zap.String("app", app), zap.String("remote", r.RemoteAddr))
+			http.Error(w, "client may not report for "+app, http.StatusForbidden)
+			return
+		}
+		if err := s.Rules.Validation().validate(ctype, app); err != nil {
+			span.SetError(err)
+			s.Instruments.fail(FailureValidation)
+			log.Warn("rejected call", zap.String("type", ctype), zap.String("app", app), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = s.count(r.Context(), req, ctype, app, hits)
+		span.SetError(err)
+		if _, ok := err.(limitError); ok {
+			log.Warn("rejected call", zap.String("type", ctype), zap.String("app", app), zap.Error(err))
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
 		if err != nil {
-			s.Log.Error("failed incrementing", zap.Error(err))
+			log.Error("failed incrementing", zap.Error(err))
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			panic(err)
 		}
 		w.WriteHeader(http.StatusOK)
-		s.Log.Info("finished handling", zap.String("type", req.Type), zap.String("app", req.App))
+		log.Info("finished handling", zap.String("type", ctype), zap.String("app", app))
 	}
 }
 
-// Count increments the db and prom counter
-func (s Server) Count(ctype, app string) error {
-	s.Counter.With("type", ctype, "app", app).Add(1)
-	return s.Db.Update(func(tx *bolt.Tx) (err error) {
-		b := tx.Bucket([]byte(app))
-		if b == nil {
-			b, err = tx.CreateBucket([]byte(app))
-			if err != nil {
-				s.Log.Error("db bucketCreate error",
-					zap.String("type", ctype),
-					zap.String("app", app),
-					zap.Error(err),
-				)
-				return err
-			}
-			err = b.Put([]byte(ctype), []byte("0"))
-			if err != nil {
-				s.Log.Error("db put error",
-					zap.String("type", ctype),
-					zap.String("app", app),
-					zap.Error(err),
-				)
-				return err
+// LoadMetrics exports the stored counts of all apps
+func (s Server) LoadMetrics() (apps int, err error) {
+	var totals Totals
+	var st SeenTimes
+	var since time.Time
+	err = s.Db.View(func(tx *bolt.Tx) error {
+		if totals, err = ReadTotals(tx); err != nil {
+			return err
+		}
+		since = seenSince(tx)
+		st, err = s.readSeen(tx)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.Stale.setSince(since)
+	s.replaceSeries(nil, totals, st)
+	s.reloadSeen(st)
+	return len(totals), nil
+}
+
+// replaceSeries removes the series of before and exports the counts of after, except for series stale according to st
+//
+// Apart from the initial load it has to be called with SeriesMu held, which keeps it in sync with Count.
+func (s Server) replaceSeries(before, after Totals, st SeenTimes) {
+	for app, types := range before {
+		for ctype := range types {
+			s.CounterVec.DeleteLabelValues(ctype, app)
+			s.Stale.reveal(app, ctype)
+		}
+	}
+	now := time.Now()
+	for app, types := range after {
+		for ctype, n := range types {
+			if s.Stale.stale(st[app][ctype].Last, now) {
+				s.Stale.hide(app, ctype)
+				continue
 			}
+			s.Counter.With(s.Metric.labels(ctype, app)...).Add(float64(n))
 		}
-		v := b.Get([]byte(ctype))
-		if len(v) < 1 {
-			v = []byte("0")
+	}
+}
+
+// Count increments the db and prom counter
+func (s Server) Count(ctype, app string) error {
+	return s.count(context.Background(), Request{Type: ctype, App: app}, ctype, app, nil)
+}
+
+// count increments the counters of ctype and app, which have been resolved from req using hits
+func (s Server) count(ctx context.Context, req Request, ctype, app string, hits []AliasHit) (err error) {
+	defer s.Instruments.observeCount(time.Now())
+	ctx, span := s.Tracer.Start(ctx, "insight.count")
+	defer func() {
+		span.SetError(err)
+		span.Finish()
+	}()
+	_, txSpan := s.Tracer.Start(ctx, "bolt.update")
+	defer txSpan.Finish()
+	s.SeriesMu.Lock()
+	defer s.SeriesMu.Unlock()
+	var now time.Time
+	var n uint64
+	var newApp, newType bool
+	err = s.Db.Update(func(tx *bolt.Tx) error {
+		now = time.Now()
+		var limit string
+		var err error
+		ctype, app, limit, err = s.Rules.Limits().apply(tx, ctype, app)
+		if limit != "" {
+			s.Instruments.limited(limit, err != nil)
+			s.Log.Debug("limit reached", zap.String("limit", limit), zap.String("type", req.Type), zap.String("app", req.App))
 		}
-		vi, err := strconv.Atoi(string(v))
 		if err != nil {
-			s.Log.Error("count error", zap.Error(err))
 			return err
 		}
-		vi = vi + 1
-		v = []byte(strconv.Itoa(vi))
-		err = b.Put([]byte(ctype), v)
+		newApp, newType, err = aggregate(tx, now, ctype, app, hits)
 		if err != nil {
-			s.Log.Error("db put error",
+			s.Log.Error("count error",
 				zap.String("type", ctype),
 				zap.String("app", app),
 				zap.Error(err),
 			)
 			return err
 		}
-		return nil
+		if s.EventLog {
+			err = appendEvent(tx, Event{Time: now, App: req.App, Type: req.Type, Labels: req.Labels})
+			if err != nil {
+				s.Log.Error("event log error",
+					zap.String("type", ctype),
+					zap.String("app", app),
+					zap.Error(err),
+				)
+				return err
+			}
+		}
+		n, err = DecodeCount(tx.Bucket(AppsBucket).Bucket([]byte(app)).Get([]byte(ctype)))
+		return err
 	})
+	if err != nil {
+		return err
+	}
+	// the call is only exported once it is committed
+	if s.Stale.reveal(app, ctype) {
+		// the series has been removed while stale, so it is exported with its full count again
+		s.Counter.With(s.Metric.labels(ctype, app)...).Add(float64(n))
+	} else {
+		s.Counter.With(s.Metric.labels(ctype, app)...).Add(1)
+	}
+	s.Seen.seen(now, ctype, app, newApp, newType)
+	return nil
+}
+
+// aggregate increments the total and hourly counters of ctype and app, their seen times and the hit counters of all aliases
+//
+// It reports whether app and ctype have not been seen before.
+func aggregate(tx *bolt.Tx, t time.Time, ctype, app string, hits []AliasHit) (newApp, newType bool, err error) {
+	// read before the apps change, as missing counts are rebuilt from them
+	c, err := counts(tx)
+	if err != nil {
+		return false, false, err
+	}
+	root, err := tx.CreateBucketIfNotExists(AppsBucket)
+	if err != nil {
+		return false, false, err
+	}
+	createApp := root.Bucket([]byte(app)) == nil
+	b, err := root.CreateBucketIfNotExists([]byte(app))
+	if err != nil {
+		return false, false, err
+	}
+	if b.Get([]byte(ctype)) == nil {
+		if err := addSeries(c, []byte(app), createApp); err != nil {
+			return false, false, err
+		}
+	}
+	if err := incr(b, []byte(ctype), 1); err != nil {
+		return false, false, err
+	}
+	if err := recordHistory(tx, t, ctype, app); err != nil {
+		return false, false, err
+	}
+	newApp, newType, err = recordSeen(tx, t, ctype, app)
+	if err != nil {
+		return false, false, err
+	}
+	return newApp, newType, recordAliasHits(tx, t, hits)
 }
 
 // Request defines a default request
 type Request struct {
-	Type string `json:"type"`
-	App  string `json:"app"`
+	Type   string            `json:"type"`
+	App    string            `json:"app"`
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
+// MaxLabels limits the labels of a request, which are only kept in the event log
+const MaxLabels = 16
+
+// MaxLabelLength limits the length of label keys and values
+const MaxLabelLength = 256
+
 func decodeHTTPRequest(r *http.Request) (Request, error) {
 	var req Request
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 		return req, err
 	}
+	return req, req.Validate()
+}
+
+// Validate checks that req has an app and a type and that its labels are within MaxLabels and MaxLabelLength
+func (req Request) Validate() error {
 	if req.App == "" {
-		return req, errors.New("missing key: app")
+		return validationError("missing key: app")
 	}
 	if req.Type == "" {
-		return req, errors.New("missing key: type")
+		return validationError("missing key: type")
+	}
+	if len(req.Labels) > MaxLabels {
+		return validationError(fmt.Sprintf("more than %d labels", MaxLabels))
+	}
+	for k, v := range req.Labels {
+		if len(k) > MaxLabelLength || len(v) > MaxLabelLength {
+			return validationError(fmt.Sprintf("label longer than %d characters: %.32q", MaxLabelLength, k))
+		}
 	}
-	return req, err
+	return nil
+}
+
+var errForbidden = errors.New("client may not report for app")
+
+// validationError is returned for requests which are valid json but miss required keys
+type validationError string
+
+func (e validationError) Error() string {
+	return string(e)
 }
@@ -0,0 +1,85 @@
+package insight
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// failingCommitDB runs write transactions to their end and fails them like a failed commit
+type failingCommitDB struct {
+	DB
+}
+
+func (d failingCommitDB) Update(fn func(*bolt.Tx) error) error {
+	return d.DB.Update(func(tx *bolt.Tx) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+		return errors.New("commit failed")
+	})
+}
+
+func TestCountExportsOnlyCommittedCalls(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	s := testServer(failingCommitDB{db})
+	s.Stale = NewStaleSeries(time.Hour)
+	s.Stale.hide("a", "t")
+	if err := s.Count("t", "a"); err == nil {
+		t.Fatal("got no error from a failed commit")
+	}
+	if m := exported(t, s); len(m) != 0 {
+		t.Errorf("got exported series %v after a failed commit", m)
+	}
+	if s.Stale.Hidden() != 1 {
+		t.Error("got the series revealed after a failed commit")
+	}
+
+	s.Db = db
+	if err := s.Count("t", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if m := exported(t, s); m["t/a"] != 1 {
+		t.Errorf("got exported series %v, want t/a at 1", m)
+	}
+}
+
+func TestDecodeHTTPRequestLabels(t *testing.T) {
+	long := strings.Repeat("x", MaxLabelLength+1)
+	for body, valid := range map[string]bool{
+		`{"app": "a", "type": "t", "labels": {"ci": "jenkins"}}`:     true,
+		`{"app": "a", "type": "t", "labels": {"` + long + `": "v"}}`: false,
+		`{"app": "a", "type": "t", "labels": {"k": "` + long + `"}}`: false,
+	} {
+		_, err := decodeHTTPRequest(httptest.NewRequest("POST", "/add", strings.NewReader(body)))
+		if (err == nil) != valid {
+			t.Errorf("got error %v, want valid %v for %.60s", err, valid, body)
+		}
+	}
+}
+
+func TestHandlerRejectsInvalidCalls(t *testing.T) {
+	db, done := openTestDb(t)
+	defer done()
+	s := testServer(db)
+	long := strings.Repeat("x", MaxLabelLength+1)
+	for _, body := range []string{
+		`{"type": "t"}`,
+		`{"app": "a", "type": "t", "labels": {"k": "` + long + `"}}`,
+	} {
+		w := httptest.NewRecorder()
+		Handler(s)(w, httptest.NewRequest("POST", "/add", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("got %d, want 400 for %.40s", w.Code, body)
+		}
+	}
+	if m := exported(t, s); len(m) != 0 {
+		t.Errorf("got exported series %v after invalid calls", m)
+	}
+}
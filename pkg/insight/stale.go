@@ -0,0 +1,169 @@
+package insight
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"go.uber.org/zap"
+)
+
+// staleSweepInterval is how often series are checked for staleness
+const staleSweepInterval = time.Minute
+
+// series identifies an exported app and type pair
+type series struct {
+	app, ctype string
+}
+
+// StaleSeries hides series from /metrics which have not been counted within Window
+//
+// Hidden series are exported again with their full count as soon as they are counted.
+// Series without seen times, which have only been counted before the history, are hidden
+// once they have not been counted for Window since seen times are recorded.
+type StaleSeries struct {
+	Window time.Duration
+
+	mu     sync.Mutex
+	hidden map[series]bool
+	since  time.Time
+}
+
+// NewStaleSeries creates the staleness tracking for window
+func NewStaleSeries(window time.Duration) *StaleSeries {
+	return &StaleSeries{Window: window, hidden: make(map[series]bool)}
+}
+
+// stale reports whether a series last counted at last, or the zero time if unknown, is stale at now
+func (st *StaleSeries) stale(last, now time.Time) bool {
+	if st == nil || st.Window <= 0 {
+		return false
+	}
+	if last.IsZero() {
+		st.mu.Lock()
+		last = st.since
+		st.mu.Unlock()
+		if last.IsZero() {
+			return false
+		}
+	}
+	return last.Before(now.Add(-st.Window))
+}
+
+// setSince sets since when seen times are recorded, which is the last call assumed for series without them
+func (st *StaleSeries) setSince(since time.Time) {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.since = since
+}
+
+// hide marks the series as hidden and reports whether it has been exported before
+func (st *StaleSeries) hide(app, ctype string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.hidden[series{app, ctype}] {
+		return false
+	}
+	st.hidden[series{app, ctype}] = true
+	return true
+}
+
+// reveal unmarks the series and reports whether it has been hidden
+func (st *StaleSeries) reveal(app, ctype string) bool {
+	if st == nil {
+		return false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !st.hidden[series{app, ctype}] {
+		return false
+	}
+	delete(st.hidden, series{app, ctype})
+	return true
+}
+
+// Hidden returns the number of hidden series
+func (st *StaleSeries) Hidden() int {
+	if st == nil {
+		return 0
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return len(st.hidden)
+}
+
+// HideStaleSeries blocks until done is closed and regularly removes stale series from /metrics
+func (s Server) HideStaleSeries(done <-chan struct{}) {
+	ticker := time.NewTicker(staleSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			n, err := s.hideStale(now)
+			if err != nil {
+				s.Log.Error("stale series error", zap.Error(err))
+				continue
+			}
+			if n > 0 {
+				s.Log.Info("hid stale series", zap.Int("series", n), zap.Int("hidden", s.Stale.Hidden()))
+			}
+		}
+	}
+}
+
+// hideStale removes all series which became stale at now and returns their number
+//
+// It holds SeriesMu to be serialized with Count.
+func (s Server) hideStale(now time.Time) (n int, err error) {
+	s.SeriesMu.Lock()
+	defer s.SeriesMu.Unlock()
+	var totals Totals
+	var st SeenTimes
+	err = s.Db.View(func(tx *bolt.Tx) (err error) {
+		if totals, err = ReadTotals(tx); err != nil {
+			return err
+		}
+		st, err = ReadSeen(tx)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	for app, types := range totals {
+		for ctype := range types {
+			if s.Stale.stale(st[app][ctype].Last, now) && s.Stale.hide(app, ctype) {
+				s.CounterVec.DeleteLabelValues(ctype, app)
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+// TotalsHandler returns the all time counts of all apps including stale ones, or of a single app
+func TotalsHandler(s Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var totals Totals
+		err := s.Db.View(func(tx *bolt.Tx) (err error) {
+			if app := r.URL.Query().Get("app"); app != "" {
+				totals, err = readApps(tx, []string{app})
+				return err
+			}
+			totals, err = ReadTotals(tx)
+			return err
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(totals)
+	}
+}
@@ -0,0 +1,194 @@
+package insight
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/seibert-media/inf-insight/pkg/dbutil"
+)
+
+// DB runs transactions, it is implemented by *bolt.DB and *Store
+type DB interface {
+	View(fn func(*bolt.Tx) error) error
+	Update(fn func(*bolt.Tx) error) error
+}
+
+// compactAttempts is how often Compact copies the db while it is written, the last time with writes blocked
+const compactAttempts = 3
+
+// Store is a bolt db which can be compacted while the server is running
+//
+// Transactions must not be nested, as compaction waits for all of them to finish.
+type Store struct {
+	// mu is only held exclusively while the compacted file replaces the db
+	mu     sync.RWMutex
+	writes sync.RWMutex
+	db     *bolt.DB
+	path   string
+	mode   os.FileMode
+	opts   *bolt.Options
+	tx     *prometheus.HistogramVec
+	// err is set if the db could not be reopened, which fails all following transactions
+	err error
+}
+
+// OpenStore opens the bolt db at path
+func OpenStore(path string, mode os.FileMode, opts *bolt.Options) (*Store, error) {
+	db, err := bolt.Open(path, mode, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db, path: path, mode: mode, opts: opts}, nil
+}
+
+// Instrument records the duration of all following transactions in h, labeled view or update
+func (s *Store) Instrument(h *prometheus.HistogramVec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tx = h
+}
+
+// View runs fn in a read transaction
+func (s *Store) View(fn func(*bolt.Tx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return s.err
+	}
+	defer s.observe("view", time.Now())
+	return s.db.View(fn)
+}
+
+// Update runs fn in a write transaction
+func (s *Store) Update(fn func(*bolt.Tx) error) error {
+	s.writes.RLock()
+	defer s.writes.RUnlock()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.err != nil {
+		return s.err
+	}
+	defer s.observe("update", time.Now())
+	return s.db.Update(fn)
+}
+
+func (s *Store) observe(kind string, start time.Time) {
+	if s.tx != nil {
+		s.tx.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Stats returns the bolt statistics of the current db
+func (s *Store) Stats() bolt.Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.db.Stats()
+}
+
+// Path returns the file path of the db
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Close closes the db
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.db.Close()
+}
+
+// FreeRatio returns the share of the db file taken by free pages
+func (s *Store) FreeRatio() (float64, error) {
+	info, err := os.Stat(s.path)
+	if err != nil || info.Size() == 0 {
+		return 0, err
+	}
+	return float64(s.Stats().FreeAlloc) / float64(info.Size()), nil
+}
+
+// Compact rewrites the db file without free pages
+//
+// The db is copied from a read transaction while calls are counted, and all transactions are
+// only blocked while the copy replaces it. If the db has been written during the copy,
+// it is copied again, the last time with writes blocked.
+// If the replaced db can not be opened, all following transactions fail.
+func (s *Store) Compact() (before, after int64, err error) {
+	tmp := s.path + ".compact"
+	defer os.Remove(tmp)
+	for attempt := 1; ; attempt++ {
+		if attempt == compactAttempts {
+			s.writes.Lock()
+			defer s.writes.Unlock()
+		}
+		var txid int
+		err := s.View(func(tx *bolt.Tx) (err error) {
+			txid = tx.ID()
+			after, err = dbutil.CompactTx(tx, tmp, s.mode)
+			return err
+		})
+		if err != nil {
+			return 0, 0, err
+		}
+		size, replaced, err := s.replace(tmp, txid)
+		if err != nil || replaced {
+			return size, after, err
+		}
+	}
+}
+
+// replace closes the db, renames tmp over it and opens it again, unless it has been written after txid
+func (s *Store) replace(tmp string, txid int) (size int64, replaced bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, false, s.err
+	}
+	var current int
+	s.db.View(func(tx *bolt.Tx) error {
+		current = tx.ID()
+		return nil
+	})
+	if current != txid {
+		return 0, false, nil
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := s.db.Close(); err != nil {
+		return 0, false, err
+	}
+	renameErr := os.Rename(tmp, s.path)
+	db, err := bolt.Open(s.path, s.mode, s.opts)
+	if err != nil {
+		s.err = fmt.Errorf("db could not be opened after compaction: %v", err)
+		return 0, false, s.err
+	}
+	s.db = db
+	return info.Size(), renameErr == nil, renameErr
+}
+
+// StoreStats is the response of StoreStatsHandler
+type StoreStats struct {
+	Path string     `json:"path"`
+	Size int64      `json:"size"`
+	Bolt bolt.Stats `json:"bolt"`
+}
+
+// StoreStatsHandler returns the file size and bolt statistics of s
+func StoreStatsHandler(s *Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stats := StoreStats{Path: s.Path(), Bolt: s.Stats()}
+		if fi, err := os.Stat(s.Path()); err == nil {
+			stats.Size = fi.Size()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	})
+}
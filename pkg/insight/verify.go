@@ -0,0 +1,147 @@
+package insight
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+)
+
+// Problem describes an invalid value found in the db
+type Problem struct {
+	Bucket [][]byte
+	Key    []byte
+	Value  []byte
+	Err    error
+}
+
+func (p Problem) String() string {
+	if len(p.Bucket) < 1 {
+		return p.Err.Error()
+	}
+	return fmt.Sprintf("%s/%s=%q: %v", bytes.Join(p.Bucket, []byte("/")), p.Key, p.Value, p.Err)
+}
+
+// Verify runs the bolt consistency check and reports every counter and seen time which can not be decoded
+func Verify(tx *bolt.Tx) (problems []Problem, err error) {
+	for err := range tx.Check() {
+		problems = append(problems, Problem{Err: err})
+	}
+	version, err := SchemaVersion(tx)
+	if err != nil {
+		return problems, err
+	}
+	if version != CurrentSchema() {
+		return append(problems, Problem{Err: fmt.Errorf("schema version %d, expected %d, run \"insight db migrate\"", version, CurrentSchema())}), nil
+	}
+	if apps := tx.Bucket(AppsBucket); apps != nil {
+		apps.ForEach(func(app, _ []byte) error {
+			problems = append(problems, verifyCounters(apps, [][]byte{AppsBucket, clone(app)})...)
+			return nil
+		})
+	}
+	for _, name := range [][]byte{HistoryBucket, DailyBucket} {
+		history := tx.Bucket(name)
+		if history == nil {
+			continue
+		}
+		history.ForEach(func(period, _ []byte) error {
+			b := history.Bucket(period)
+			if b == nil {
+				problems = append(problems, Problem{Bucket: [][]byte{name}, Key: clone(period), Err: errors.New("not a bucket")})
+				return nil
+			}
+			return b.ForEach(func(app, _ []byte) error {
+				problems = append(problems, verifyCounters(b, [][]byte{name, clone(period), clone(app)})...)
+				return nil
+			})
+		})
+	}
+	if seen := tx.Bucket(SeenBucket); seen != nil {
+		seen.ForEach(func(app, _ []byte) error {
+			b := seen.Bucket(app)
+			if b == nil {
+				problems = append(problems, Problem{Bucket: [][]byte{SeenBucket}, Key: clone(app), Err: errors.New("not a bucket")})
+				return nil
+			}
+			return b.ForEach(func(ctype, v []byte) error {
+				if _, err := decodeSeen(v); err != nil {
+					problems = append(problems, Problem{Bucket: [][]byte{SeenBucket, clone(app)}, Key: clone(ctype), Value: clone(v), Err: err})
+				}
+				return nil
+			})
+		})
+	}
+	return problems, nil
+}
+
+// verifyCounters checks that the last bucket of path, which is a child of parent, only holds counters
+func verifyCounters(parent *bolt.Bucket, path [][]byte) (problems []Problem) {
+	name := path[len(path)-1]
+	b := parent.Bucket(name)
+	if b == nil {
+		return []Problem{{Bucket: path[:len(path)-1], Key: name, Value: clone(parent.Get(name)), Err: errors.New("not a bucket")}}
+	}
+	b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			problems = append(problems, Problem{Bucket: path, Key: clone(k), Err: errors.New("unexpected bucket")})
+			return nil
+		}
+		if _, err := DecodeCount(v); err != nil {
+			problems = append(problems, Problem{Bucket: path, Key: clone(k), Value: clone(v), Err: err})
+		}
+		return nil
+	})
+	return problems
+}
+
+// Quarantine moves the values of the given problems into the quarantine bucket, keeping their original path
+//
+// Problems which are not about a single value, like consistency errors, are skipped.
+// It returns the number of moved values.
+func Quarantine(tx *bolt.Tx, problems []Problem) (int, error) {
+	var n int
+	for _, p := range problems {
+		if len(p.Bucket) < 1 || p.Key == nil || p.Value == nil {
+			continue
+		}
+		src := tx.Bucket(p.Bucket[0])
+		for _, name := range p.Bucket[1:] {
+			if src == nil {
+				break
+			}
+			src = src.Bucket(name)
+		}
+		if src == nil {
+			continue
+		}
+		dst, err := tx.CreateBucketIfNotExists(QuarantineBucket)
+		if err != nil {
+			return n, err
+		}
+		for _, name := range p.Bucket {
+			dst, err = dst.CreateBucketIfNotExists(name)
+			if err != nil {
+				return n, err
+			}
+		}
+		if err := dst.Put(p.Key, p.Value); err != nil {
+			return n, err
+		}
+		if err := src.Delete(p.Key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if n > 0 {
+		return n, ResetCounts(tx)
+	}
+	return n, nil
+}
+
+func clone(b []byte) []byte {
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
@@ -0,0 +1,94 @@
+// Package report sends errors and panics to an error tracker
+package report
+
+import (
+	"net/http"
+
+	raven "github.com/getsentry/raven-go"
+	"go.uber.org/zap"
+)
+
+// Reporter sends errors to an error tracker
+//
+// The request is optional and is scrubbed before it leaves the process.
+type Reporter interface {
+	Report(err error, r *http.Request, tags map[string]string)
+	// Flush waits until all reports are sent
+	Flush()
+}
+
+// Nop drops all reports
+type Nop struct{}
+
+// Report does nothing
+func (Nop) Report(err error, r *http.Request, tags map[string]string) {}
+
+// Flush does nothing
+func (Nop) Flush() {}
+
+// Log writes reports to a logger
+type Log struct {
+	Log *zap.Logger
+}
+
+// Report logs err with the method and path of r
+func (l Log) Report(err error, r *http.Request, tags map[string]string) {
+	log := l.Log
+	if r != nil {
+		log = log.With(zap.String("method", r.Method), zap.String("path", r.URL.Path))
+	}
+	for k, v := range tags {
+		log = log.With(zap.String(k, v))
+	}
+	log.Error("reported error", zap.Error(err))
+}
+
+// Flush does nothing, as reports are logged synchronously
+func (Log) Flush() {}
+
+// Sentry sends reports to sentry
+type Sentry struct {
+	client *raven.Client
+}
+
+// NewSentry creates a reporter sending to dsn, tagging every report with release
+func NewSentry(dsn, release string) (*Sentry, error) {
+	client, err := raven.New(dsn)
+	if err != nil {
+		return nil, err
+	}
+	client.SetRelease(release)
+	return &Sentry{client: client}, nil
+}
+
+// Report sends err with a stacktrace of the caller
+func (s *Sentry) Report(err error, r *http.Request, tags map[string]string) {
+	interfaces := []raven.Interface{raven.NewException(err, raven.GetOrNewStacktrace(err, 2, 3, nil))}
+	if r != nil {
+		interfaces = append(interfaces, scrub(r))
+	}
+	s.client.Capture(raven.NewPacket(err.Error(), interfaces...), tags)
+}
+
+// Flush waits until all reports are sent
+func (s *Sentry) Flush() {
+	s.client.Wait()
+}
+
+// keptHeaders are the only headers sent along with a request
+var keptHeaders = []string{"Content-Length", "Content-Type", "User-Agent"}
+
+// scrub describes r without its body, cookies, query, client address and any header not in keptHeaders
+func scrub(r *http.Request) *raven.Http {
+	h := &raven.Http{
+		Method:  r.Method,
+		URL:     r.URL.Path,
+		Headers: make(map[string]string),
+	}
+	for _, k := range keptHeaders {
+		if v := r.Header.Get(k); v != "" {
+			h.Headers[k] = v
+		}
+	}
+	return h
+}
@@ -0,0 +1,134 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// checkInterval limits how often the certificate files are checked for changes
+const checkInterval = 10 * time.Second
+
+// Reloader serves a certificate which is read again whenever its files change on disk
+type Reloader struct {
+	Log      *zap.Logger
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// NewReloader loads the certificate and key pair from the given files
+func NewReloader(log *zap.Logger, certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{Log: log, certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the pair if one of the files has been modified since the last load
+func (r *Reloader) load() error {
+	modTime, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert, r.modTime = &cert, modTime
+	return nil
+}
+
+func latestModTime(files ...string) (time.Time, error) {
+	var latest time.Time
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return latest, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+//
+// If reloading fails, the previous certificate is kept.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now := time.Now(); now.Sub(r.checked) >= checkInterval {
+		r.checked = now
+		previous := r.cert
+		if err := r.load(); err != nil {
+			r.Log.Error("certificate reload error", zap.String("cert", r.certFile), zap.Error(err))
+		} else if r.cert != previous {
+			r.Log.Info("reloaded certificate", zap.String("cert", r.certFile))
+		}
+	}
+	return r.cert, nil
+}
+
+// ServerConfig returns the tls config serving the certificates of r
+//
+// With a clientCA bundle client certificates are verified against it, and required if requireClient is set.
+func ServerConfig(r *Reloader, clientCA string, requireClient bool) (*tls.Config, error) {
+	config := &tls.Config{
+		GetCertificate: r.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if clientCA == "" {
+		return config, nil
+	}
+	pem, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + clientCA)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if requireClient {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// RequireClientCert rejects requests without a verified client certificate, except for the exempt paths
+//
+// It is used with a config which only verifies client certificates if given, so probes without one
+// still reach the health endpoints.
+func RequireClientCert(next http.Handler, exempt ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			for _, path := range exempt {
+				if r.URL.Path == path {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "client certificate required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,129 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// writePair writes a self-signed certificate for cn and its key to the given files
+func writePair(t *testing.T, certFile, keyFile, cn string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touch moves the modification time of files forward, as rewrites within a second may keep it
+func touch(t *testing.T, d time.Duration, files ...string) {
+	for _, f := range files {
+		if err := os.Chtimes(f, time.Now().Add(d), time.Now().Add(d)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// served returns the common name of the certificate r serves once the check interval has passed
+func served(t *testing.T, r *Reloader) string {
+	r.mu.Lock()
+	r.checked = time.Time{}
+	r.mu.Unlock()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "insight-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writePair(t, certFile, keyFile, "first")
+	r, err := NewReloader(zap.NewNop(), certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := served(t, r); cn != "first" {
+		t.Fatalf("got %s, want first", cn)
+	}
+
+	writePair(t, certFile, keyFile, "second")
+	touch(t, time.Minute, certFile, keyFile)
+	if cn := served(t, r); cn != "second" {
+		t.Errorf("got %s after rewriting the files, want second", cn)
+	}
+
+	if err := ioutil.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, 2*time.Minute, certFile)
+	if cn := served(t, r); cn != "second" {
+		t.Errorf("got %s after breaking the files, want second kept", cn)
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	h := RequireClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "/healthz")
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	tests := []struct {
+		path  string
+		state *tls.ConnectionState
+		want  int
+	}{
+		{"/metrics", verified, http.StatusOK},
+		{"/metrics", &tls.ConnectionState{}, http.StatusForbidden},
+		{"/metrics", nil, http.StatusForbidden},
+		{"/healthz", &tls.ConnectionState{}, http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.path, nil)
+		r.TLS = test.state
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: got %d, want %d", test.path, w.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exporter sends finished spans to a tracing backend
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// Writer exports every span as a line of json, which is meant for testing
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter creates an exporter writing to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+type writtenSpan struct {
+	Name       string            `json:"name"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	Duration   string            `json:"duration"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// Export writes spans
+func (e *Writer) Export(spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	enc := json.NewEncoder(e.w)
+	for _, s := range spans {
+		ws := writtenSpan{
+			Name:       s.Name,
+			TraceID:    hex.EncodeToString(s.Context.TraceID[:]),
+			SpanID:     hex.EncodeToString(s.Context.SpanID[:]),
+			Start:      s.Start,
+			Duration:   s.End.Sub(s.Start).String(),
+			Attributes: s.Attributes,
+		}
+		if s.Parent != [8]byte{} {
+			ws.ParentID = hex.EncodeToString(s.Parent[:])
+		}
+		if s.Err != nil {
+			ws.Error = s.Err.Error()
+		}
+		if err := enc.Encode(ws); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OTLP exports spans with the OTLP/HTTP json encoding
+type OTLP struct {
+	// Endpoint is the full url, usually ending in /v1/traces
+	Endpoint string
+	Service  string
+	Headers  map[string]string
+	Client   *http.Client
+}
+
+// NewOTLP creates an exporter posting to endpoint, naming the spans' resource service
+func NewOTLP(endpoint, service string) *OTLP {
+	return &OTLP{
+		Endpoint: endpoint,
+		Service:  service,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              Kind            `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func attributes(m map[string]string) []otlpAttribute {
+	var attrs []otlpAttribute
+	for k, v := range m {
+		attrs = append(attrs, otlpAttribute{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Key < attrs[j].Key })
+	return attrs
+}
+
+// Export posts spans as a single request
+func (e *OTLP) Export(spans []*Span) error {
+	var ss otlpScopeSpans
+	ss.Scope.Name = e.Service
+	for _, s := range spans {
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.Context.SpanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        attributes(s.Attributes),
+		}
+		if s.Parent != [8]byte{} {
+			o.ParentSpanID = hex.EncodeToString(s.Parent[:])
+		}
+		if s.Err != nil {
+			o.Status = otlpStatus{Code: 2, Message: s.Err.Error()}
+		}
+		ss.Spans = append(ss.Spans, o)
+	}
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{ss}}
+	rs.Resource.Attributes = attributes(map[string]string{"service.name": e.Service})
+	req := otlpRequest{ResourceSpans: []otlpResourceSpans{rs}}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest(http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		r.Header.Set(k, v)
+	}
+	resp, err := e.Client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("otlp endpoint responded with %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+// Package trace records spans, propagates them with W3C traceparent headers and exports them in batches
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TraceparentHeader carries the span context of a request
+const TraceparentHeader = "traceparent"
+
+// SpanContext identifies a span within a trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// Valid reports whether neither id is all zeros
+func (sc SpanContext) Valid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as traceparent header value
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent reads a traceparent header value
+//
+// Versions other than 00 are read as far as version 00 defines them, as the spec requires.
+func ParseTraceparent(h string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, fmt.Errorf("malformed traceparent %q", h)
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("unsupported traceparent %q", h)
+	}
+	var flags [1]byte
+	for _, p := range []struct {
+		dst []byte
+		src string
+	}{{sc.TraceID[:], parts[1]}, {sc.SpanID[:], parts[2]}, {flags[:], parts[3]}} {
+		if strings.ToLower(p.src) != p.src {
+			return sc, fmt.Errorf("malformed traceparent %q", h)
+		}
+		if _, err := hex.Decode(p.dst, []byte(p.src)); err != nil {
+			return sc, fmt.Errorf("malformed traceparent %q", h)
+		}
+	}
+	if !sc.Valid() {
+		return sc, fmt.Errorf("invalid ids in traceparent %q", h)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+// Kind tells whether a span handles a request or is internal
+type Kind int
+
+// Kinds as numbered by OTLP
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+)
+
+// Span is a timed operation
+//
+// All methods may be called on a nil span, which is what a nil Tracer starts.
+type Span struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     [8]byte
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Err        error
+
+	tracer *Tracer
+	once   sync.Once
+}
+
+// SetAttribute annotates the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.Attributes[key] = value
+}
+
+// SetError marks the span as failed if err is not nil
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Err = err
+}
+
+// Finish ends the span and queues it for export if it is sampled
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		s.End = time.Now()
+		if s.Context.Sampled {
+			s.tracer.queue(s)
+		}
+	})
+}
+
+type spanKey struct{}
+
+// FromContext returns the span started last within ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// Tracer starts spans and exports them in batches while running
+//
+// A nil Tracer starts nil spans, so tracing can be disabled by not creating one.
+type Tracer struct {
+	Log      *zap.Logger
+	Exporter Exporter
+	// Sample is the ratio of traces started here which are recorded, calls with a traceparent follow its sampled flag
+	Sample float64
+	// BatchSize and Interval limit how many spans are exported at once and how long they are held back
+	BatchSize int
+	Interval  time.Duration
+
+	spans   chan *Span
+	dropped uint64
+}
+
+// NewTracer creates a tracer which exports to e once it runs
+func NewTracer(log *zap.Logger, e Exporter, sample float64) *Tracer {
+	return &Tracer{
+		Log:       log,
+		Exporter:  e,
+		Sample:    sample,
+		BatchSize: 512,
+		Interval:  5 * time.Second,
+		spans:     make(chan *Span, 2048),
+	}
+}
+
+// Start begins a child span of the span in ctx, or a new trace if there is none
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	var parent SpanContext
+	if p := FromContext(ctx); p != nil {
+		parent = p.Context
+	}
+	return t.start(ctx, name, KindInternal, parent)
+}
+
+// StartRequest begins the server span of r, continuing the trace of its traceparent header if it has a valid one
+func (t *Tracer) StartRequest(r *http.Request, name string) (*http.Request, *Span) {
+	if t == nil {
+		return r, nil
+	}
+	parent, _ := ParseTraceparent(r.Header.Get(TraceparentHeader))
+	ctx, span := t.start(r.Context(), name, KindServer, parent)
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.target", r.URL.Path)
+	return r.WithContext(ctx), span
+}
+
+func (t *Tracer) start(ctx context.Context, name string, kind Kind, parent SpanContext) (context.Context, *Span) {
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]string),
+		tracer:     t,
+	}
+	if parent.Valid() {
+		s.Context.TraceID = parent.TraceID
+		s.Context.Sampled = parent.Sampled
+		s.Parent = parent.SpanID
+	} else {
+		randomID(s.Context.TraceID[:])
+		s.Context.Sampled = t.sampled(s.Context.TraceID)
+	}
+	randomID(s.Context.SpanID[:])
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// sampled decides by the trace id, so all spans of a trace are sampled alike
+func (t *Tracer) sampled(id [16]byte) bool {
+	if t.Sample >= 1 {
+		return true
+	}
+	if t.Sample <= 0 {
+		return false
+	}
+	return float64(binary.BigEndian.Uint64(id[8:])>>11)/(1<<53) < t.Sample
+}
+
+func (t *Tracer) queue(s *Span) {
+	select {
+	case t.spans <- s:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// Run exports the finished spans until done is closed, then exports the remaining ones
+func (t *Tracer) Run(done <-chan struct{}) {
+	ticker := time.NewTicker(t.Interval)
+	defer ticker.Stop()
+	var batch []*Span
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.Exporter.Export(batch); err != nil {
+			t.Log.Error("span export error", zap.Int("spans", len(batch)), zap.Error(err))
+		}
+		if dropped := atomic.SwapUint64(&t.dropped, 0); dropped > 0 {
+			t.Log.Warn("dropped spans, the export queue was full", zap.Uint64("spans", dropped))
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case s := <-t.spans:
+			batch = append(batch, s)
+			if len(batch) >= t.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-done:
+			for {
+				select {
+				case s := <-t.spans:
+					batch = append(batch, s)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func randomID(b []byte) {
+	for {
+		rand.Read(b)
+		for _, c := range b {
+			if c != 0 {
+				return
+			}
+		}
+	}
+}
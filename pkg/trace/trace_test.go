@@ -0,0 +1,57 @@
+package trace
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header  string
+		trace   string
+		span    string
+		sampled bool
+		err     bool
+	}{
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", trace: "4bf92f3577b34da6a3ce929d0e0e4736", span: "00f067aa0ba902b7", sampled: true},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", trace: "4bf92f3577b34da6a3ce929d0e0e4736", span: "00f067aa0ba902b7"},
+		{header: " 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03 ", trace: "4bf92f3577b34da6a3ce929d0e0e4736", span: "00f067aa0ba902b7", sampled: true},
+		// later versions may append fields
+		{header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", trace: "4bf92f3577b34da6a3ce929d0e0e4736", span: "00f067aa0ba902b7", sampled: true},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", err: true},
+		{header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", err: true},
+		{header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", err: true},
+		{header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", err: true},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", err: true},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", err: true},
+		{header: "00-4bf92f3577b34da6a3ce929d0e0e473g-00f067aa0ba902b7-01", err: true},
+		{header: "", err: true},
+	}
+	for _, test := range tests {
+		sc, err := ParseTraceparent(test.header)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: got %+v, want an error", test.header, sc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.header, err)
+			continue
+		}
+		if hex.EncodeToString(sc.TraceID[:]) != test.trace || hex.EncodeToString(sc.SpanID[:]) != test.span || sc.Sampled != test.sampled {
+			t.Errorf("%q: got %+v", test.header, sc)
+		}
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	var sc SpanContext
+	randomID(sc.TraceID[:])
+	randomID(sc.SpanID[:])
+	sc.Sampled = true
+	parsed, err := ParseTraceparent(sc.Traceparent())
+	if err != nil || parsed != sc {
+		t.Errorf("got %+v, %v, want %+v", parsed, err, sc)
+	}
+}